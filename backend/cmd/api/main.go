@@ -1,8 +1,11 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -11,86 +14,281 @@ import (
 	"github.com/onlinejudge/backend/internal/services"
 	"github.com/onlinejudge/backend/pkg/broker"
 	"github.com/onlinejudge/backend/pkg/database"
+	"github.com/onlinejudge/backend/pkg/logging"
+	"github.com/onlinejudge/backend/pkg/mailer"
+	"github.com/onlinejudge/backend/pkg/metrics"
+	"github.com/onlinejudge/backend/pkg/ratelimit"
+	"github.com/onlinejudge/backend/pkg/storage"
+	"github.com/onlinejudge/backend/pkg/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
 func main() {
 	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: .env file not found")
+	envErr := godotenv.Load()
+
+	// Log JSON lines through slog, see logging.Setup
+	logging.Setup()
+	if envErr != nil {
+		slog.Warn(".env file not found")
+	}
+
+	// Export traces over OTLP when an endpoint is configured
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		fatal("failed to set up tracing", err)
 	}
+	defer shutdownTracing(context.Background())
 
 	// Initialize database
 	db, err := database.InitDB()
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("failed to initialize database", err)
+	}
+
+	// Give problems from before revisions existed their first revision
+	if err := services.BackfillRevisions(db); err != nil {
+		fatal("failed to backfill problem revisions", err)
 	}
 
 	// Initialize NATS client
 	natsClient, err := broker.NewNATSClient()
 	if err != nil {
-		log.Fatalf("Failed to connect to NATS: %v", err)
+		fatal("failed to connect to NATS", err)
 	}
 	defer natsClient.Close()
 
+	// Initialize blob storage for statement attachments
+	store, err := storage.NewStore()
+	if err != nil {
+		fatal("failed to initialize storage", err)
+	}
+
+	// Initialize mailer for verification and password reset emails
+	mail, err := mailer.NewMailer()
+	if err != nil {
+		fatal("failed to initialize mailer", err)
+	}
+
+	// Load single sign-on identity providers
+	oidcProviders, err := services.LoadOIDCProviders()
+	if err != nil {
+		fatal("failed to load OIDC providers", err)
+	}
+
 	// Initialize evaluator
 	evaluator, err := services.NewEvaluator()
 	if err != nil {
-		log.Fatalf("Failed to initialize evaluator: %v", err)
+		fatal("failed to initialize evaluator", err)
 	}
 
 	// Subscribe to submission evaluations
 	if err := natsClient.SubscribeToSubmissions(evaluator); err != nil {
-		log.Fatalf("Failed to subscribe to submissions: %v", err)
+		fatal("failed to subscribe to submissions", err)
+	}
+	if err := natsClient.SubscribeToRevisions(evaluator); err != nil {
+		fatal("failed to subscribe to revisions", err)
 	}
 
 	// Initialize handlers
 	userHandler := handlers.NewUserHandler(db)
-	problemHandler := handlers.NewProblemHandler(db)
+	accountHandler := handlers.NewAccountHandler(db, mail)
+	oidcHandler := handlers.NewOIDCHandler(db, oidcProviders)
+	twoFactorHandler := handlers.NewTwoFactorHandler(db)
+	problemHandler := handlers.NewProblemHandler(db, natsClient, evaluator)
 	contestHandler := handlers.NewContestHandler(db)
-	submissionHandler := handlers.NewSubmissionHandler(db, natsClient)
+	submissionHandler := handlers.NewSubmissionHandler(db, natsClient, evaluator)
+	revisionHandler := handlers.NewRevisionHandler(db, natsClient)
+	statementHandler := handlers.NewStatementHandler(db, store)
+	statsHandler := handlers.NewStatsHandler(db)
+	runHandler := handlers.NewRunHandler(evaluator)
+	plagiarismHandler := handlers.NewPlagiarismHandler(db)
+	sessionHandler := handlers.NewSessionHandler(db)
+	apiTokenHandler := handlers.NewAPITokenHandler(db)
+	adminHandler := handlers.NewAdminHandler(db)
+	auditHandler := handlers.NewAuditHandler(db)
+	problemGrantHandler := handlers.NewGrantHandler(db, services.ResourceProblem)
+	contestGrantHandler := handlers.NewGrantHandler(db, services.ResourceContest)
+
+	// Permission checks on the problem or contest named by :id
+	problemOn := func(perm services.Permission) gin.HandlerFunc {
+		return middleware.RequireOn(db, perm, services.ResourceProblem)
+	}
+	contestOn := func(perm services.Permission) gin.HandlerFunc {
+		return middleware.RequireOn(db, perm, services.ResourceContest)
+	}
+
+	// Initialize rate limiter
+	limiter, err := ratelimit.NewLimiter()
+	if err != nil {
+		fatal("failed to initialize rate limiter", err)
+	}
+
+	// Rate limits per route group as per-IP and per-user defaults, see
+	// NewRateLimitPolicy for overriding them
+	rateLimit := func(name, perIP, perUser string) gin.HandlerFunc {
+		policy, err := middleware.NewRateLimitPolicy(name, perIP, perUser)
+		if err != nil {
+			fatal("invalid rate limit", err)
+		}
+		return middleware.RateLimit(limiter, policy)
+	}
+	authLimit := rateLimit("auth", "20/m:10", "")
+	submitLimit := rateLimit("submit", "", "6/m:10")
+	runLimit := rateLimit("run", "", "10/m")
 
 	// Initialize router
-	r := gin.Default()
+	r := gin.New()
+
+	// Only take the client IP from X-Forwarded-For when the request comes
+	// through one of TRUSTED_PROXIES, comma-separated IPs or CIDRs; rate
+	// limits, sessions and the audit log otherwise see the peer address
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		fatal("invalid TRUSTED_PROXIES", err)
+	}
 
 	// Middleware
+	r.Use(otelgin.Middleware(tracing.ServiceName))
+	r.Use(middleware.RequestID(), middleware.Logger(), middleware.Recovery())
+	r.Use(middleware.Metrics())
 	r.Use(middleware.CORS())
 	r.Use(middleware.ErrorHandler())
 
 	// Public routes
 	public := r.Group("/api")
+	public.Use(rateLimit("api", "300/m:60", ""))
 	{
-		public.POST("/auth/register", userHandler.Register)
-		public.POST("/auth/login", userHandler.Login)
-		public.GET("/problems", problemHandler.ListProblems)
-		public.GET("/problems/:id", problemHandler.GetProblem)
+		public.POST("/auth/register", authLimit, accountHandler.Register)
+		public.POST("/auth/verify-email", authLimit, accountHandler.VerifyEmail)
+		public.POST("/auth/verify-email/resend", authLimit, accountHandler.ResendVerification)
+		public.POST("/auth/password-reset", authLimit, accountHandler.RequestPasswordReset)
+		public.POST("/auth/password-reset/confirm", authLimit, accountHandler.ResetPassword)
+		public.POST("/auth/login", authLimit, middleware.LoginLockout(limiter), userHandler.Login)
+		public.POST("/auth/2fa/verify", authLimit, twoFactorHandler.Verify)
+		public.POST("/auth/refresh", sessionHandler.Refresh)
+		public.GET("/auth/oidc/providers", oidcHandler.ListProviders)
+		public.GET("/auth/oidc/:provider/login", authLimit, oidcHandler.Login)
+		public.GET("/auth/oidc/:provider/callback", authLimit, oidcHandler.Callback)
+		public.GET("/problems", middleware.OptionalAuth(db), problemHandler.ListProblems)
+		public.GET("/problems/:id", middleware.OptionalAuth(db), problemHandler.GetProblem)
+		public.GET("/problems/:id/statement", statementHandler.GetStatement)
+		public.GET("/problems/:id/attachments", statementHandler.ListAttachments)
+		public.GET("/problems/:id/attachments/:name", statementHandler.GetAttachment)
+		public.GET("/problems/:id/stats", statsHandler.GetProblemStats)
+		public.GET("/users/:username/profile", statsHandler.GetUserProfile)
 		public.GET("/contests", contestHandler.ListContests)
 		public.GET("/contests/:id", contestHandler.GetContest)
 	}
 
 	// Protected routes
 	protected := r.Group("/api")
-	protected.Use(middleware.Auth())
+	protected.Use(middleware.Auth(db), rateLimit("api", "", "600/m:120"))
 	{
 		// User routes
 		protected.GET("/users/me", userHandler.GetProfile)
 		protected.PUT("/users/me", userHandler.UpdateProfile)
+		protected.GET("/users/me/sessions", sessionHandler.ListSessions)
+		protected.DELETE("/users/me/sessions/:id", sessionHandler.RevokeSession)
+		protected.GET("/users/me/2fa", twoFactorHandler.Status)
+		protected.POST("/users/me/2fa", twoFactorHandler.Enroll)
+		protected.POST("/users/me/2fa/confirm", twoFactorHandler.Confirm)
+		protected.POST("/users/me/2fa/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes)
+		protected.DELETE("/users/me/2fa", twoFactorHandler.Disable)
+		protected.GET("/users/me/tokens", apiTokenHandler.ListTokens)
+		protected.POST("/users/me/tokens", apiTokenHandler.CreateToken)
+		protected.DELETE("/users/me/tokens/:id", apiTokenHandler.RevokeToken)
+		protected.POST("/auth/logout", sessionHandler.Logout)
+		protected.POST("/auth/logout-all", sessionHandler.LogoutAll)
 
 		// Problem routes
-		protected.POST("/problems", problemHandler.CreateProblem)
-		protected.PUT("/problems/:id", problemHandler.UpdateProblem)
-		protected.DELETE("/problems/:id", problemHandler.DeleteProblem)
+		protected.POST("/problems", middleware.Require(db, services.PermProblemCreate), problemHandler.CreateProblem)
+		protected.PUT("/problems/:id", problemOn(services.PermProblemEdit), problemHandler.UpdateProblem)
+		protected.DELETE("/problems/:id", problemOn(services.PermProblemDelete), problemHandler.DeleteProblem)
+		protected.POST("/problems/:id/stats/rebuild", problemOn(services.PermProblemManage), statsHandler.RebuildProblemStats)
+
+		// Problem co-authors and testers
+		protected.GET("/problems/:id/grants", problemOn(services.PermProblemGrant), problemGrantHandler.ListGrants)
+		protected.POST("/problems/:id/grants", problemOn(services.PermProblemGrant), problemGrantHandler.AddGrant)
+		protected.DELETE("/problems/:id/grants/:user", problemOn(services.PermProblemGrant), problemGrantHandler.RemoveGrant)
+
+		// Problem statement attachment routes
+		protected.POST("/problems/:id/attachments", problemOn(services.PermProblemEdit), statementHandler.UploadAttachment)
+		protected.DELETE("/problems/:id/attachments/:name", problemOn(services.PermProblemEdit), statementHandler.DeleteAttachment)
+
+		// Problem revision routes
+		protected.GET("/problems/:id/revisions", problemOn(services.PermProblemVerify), revisionHandler.ListRevisions)
+		protected.GET("/problems/:id/revisions/:number", problemOn(services.PermProblemVerify), revisionHandler.GetRevision)
+		protected.GET("/problems/:id/revisions/:number/diff", problemOn(services.PermProblemVerify), revisionHandler.DiffRevision)
+		protected.POST("/problems/:id/revisions/:number/rollback", problemOn(services.PermProblemEdit), revisionHandler.Rollback)
+		protected.POST("/problems/:id/revisions/:number/rejudge", problemOn(services.PermProblemManage), revisionHandler.Rejudge)
+		protected.GET("/problems/:id/revisions/:number/verification", problemOn(services.PermProblemVerify), revisionHandler.GetVerification)
+		protected.POST("/problems/:id/revisions/:number/verification", problemOn(services.PermProblemVerify), revisionHandler.Verify)
 
 		// Contest routes
-		protected.POST("/contests", contestHandler.CreateContest)
-		protected.PUT("/contests/:id", contestHandler.UpdateContest)
-		protected.DELETE("/contests/:id", contestHandler.DeleteContest)
+		protected.POST("/contests", middleware.Require(db, services.PermContestCreate), contestHandler.CreateContest)
+		protected.PUT("/contests/:id", contestOn(services.PermContestEdit), contestHandler.UpdateContest)
+		protected.DELETE("/contests/:id", contestOn(services.PermContestDelete), contestHandler.DeleteContest)
 		protected.POST("/contests/:id/register", contestHandler.RegisterForContest)
 
+		// Contest co-managers and judges
+		protected.GET("/contests/:id/grants", contestOn(services.PermContestGrant), contestGrantHandler.ListGrants)
+		protected.POST("/contests/:id/grants", contestOn(services.PermContestGrant), contestGrantHandler.AddGrant)
+		protected.DELETE("/contests/:id/grants/:user", contestOn(services.PermContestGrant), contestGrantHandler.RemoveGrant)
+
+		// Plagiarism review for contest managers and judges
+		protected.POST("/contests/:id/plagiarism", contestOn(services.PermContestReview), plagiarismHandler.StartCheck)
+		protected.GET("/contests/:id/plagiarism", contestOn(services.PermContestReview), plagiarismHandler.ListReports)
+		protected.GET("/contests/:id/plagiarism/:report", contestOn(services.PermContestReview), plagiarismHandler.GetReport)
+		protected.GET("/contests/:id/plagiarism/:report/pairs/:pair", contestOn(services.PermContestReview), plagiarismHandler.GetPair)
+
 		// Submission routes
-		protected.POST("/submissions", submissionHandler.Submit)
+		protected.POST("/submissions", submitLimit, submissionHandler.Submit)
+		protected.POST("/submissions/outputs", submitLimit, submissionHandler.SubmitOutputs)
 		protected.GET("/submissions/:id", submissionHandler.GetSubmission)
 		protected.GET("/submissions", submissionHandler.ListSubmissions)
 		protected.GET("/submissions/:id/results", submissionHandler.GetSubmissionResults)
+		protected.GET("/sample-runs", submissionHandler.ListSampleRuns)
+		protected.GET("/sample-runs/:id", submissionHandler.GetSampleRun)
+
+		// Custom invocation
+		protected.POST("/run", runLimit, runHandler.Run)
+	}
+
+	// Admin routes
+	admin := r.Group("/api/admin")
+	admin.Use(middleware.Auth(db), middleware.AdminMiddleware(db))
+	{
+		admin.GET("/users", adminHandler.ListUsers)
+		admin.PUT("/users/:id", adminHandler.UpdateUser)
+		admin.DELETE("/users/:id", adminHandler.DeleteUser)
+		admin.GET("/audit", auditHandler.ListAudit)
+		admin.GET("/audit/export", auditHandler.ExportAudit)
+	}
+
+	// Prometheus metrics, on their own listener so that they are never
+	// reachable through the public port
+	metricsAddr := os.Getenv("METRICS_ADDR")
+	if metricsAddr == "" {
+		metricsAddr = ":9090"
+	}
+	if metricsAddr != "off" {
+		go func() {
+			if err := http.ListenAndServe(metricsAddr, metrics.Handler()); err != nil {
+				fatal("failed to start metrics server", err)
+			}
+		}()
 	}
 
 	// Start server
@@ -100,6 +298,6 @@ func main() {
 	}
 
 	if err := r.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		fatal("failed to start server", err)
 	}
-} 
\ No newline at end of file
+}
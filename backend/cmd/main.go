@@ -33,7 +33,10 @@ func main() {
 	defer natsClient.Close()
 
 	// Initialize code evaluator
-	evaluator := services.NewEvaluator()
+	evaluator, err := services.NewEvaluator()
+	if err != nil {
+		log.Fatalf("Failed to initialize evaluator: %v", err)
+	}
 
 	// Set up Gin router
 	router := gin.Default()
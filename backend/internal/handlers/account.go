@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
+	"github.com/onlinejudge/backend/pkg/logging"
+	"github.com/onlinejudge/backend/pkg/mailer"
+	"gorm.io/gorm"
+)
+
+// AccountHandler covers the account flows that send email: registration
+// with address verification, and password reset.
+type AccountHandler struct {
+	db     *gorm.DB
+	mailer mailer.Mailer
+}
+
+func NewAccountHandler(db *gorm.DB, m mailer.Mailer) *AccountHandler {
+	return &AccountHandler{db: db, mailer: m}
+}
+
+type RegisterRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+type EmailRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type TokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+func (h *AccountHandler) Register(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check if user already exists
+	var existingUser models.User
+	if err := db.Where("email = ? OR username = ?", req.Email, req.Username).First(&existingUser).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+		return
+	}
+
+	// Create new user
+	user := models.User{
+		Username: req.Username,
+		Email:    req.Email,
+		Password: req.Password,
+	}
+
+	// Hash password
+	if err := user.HashPassword(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	// Save user
+	if err := db.Create(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	audit(c, db, services.AuditUserRegister, services.AuditTargetUser, user.ID, nil, user)
+
+	// The account exists either way; a lost email can be sent again
+	if err := services.SendVerificationEmail(c.Request.Context(), db, h.mailer, &user); err != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to send verification email", "user_id", user.ID, "error", err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":               "User created successfully",
+		"verification_required": services.EmailVerificationRequired(),
+	})
+}
+
+// VerifyEmail confirms an address with the token from a verification email.
+func (h *AccountHandler) VerifyEmail(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var req TokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := services.VerifyEmail(db, req.Token); err != nil {
+		if err == services.ErrInvalidAccountToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email address verified"})
+}
+
+// ResendVerification mails a new verification link. The response is the
+// same whether or not the address belongs to an unverified account.
+func (h *AccountHandler) ResendVerification(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var req EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := db.Where("email = ?", req.Email).First(&user).Error; err == nil {
+		err := services.SendVerificationEmail(c.Request.Context(), db, h.mailer, &user)
+		if err != nil && err != services.ErrEmailVerified {
+			logging.FromContext(c.Request.Context()).Error("failed to send verification email", "user_id", user.ID, "error", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "if the address belongs to an unverified account, a verification email has been sent"})
+}
+
+// RequestPasswordReset mails a password reset link. The response is the
+// same whether or not the address has an account.
+func (h *AccountHandler) RequestPasswordReset(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var req EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.RequestPasswordReset(c.Request.Context(), db, h.mailer, req.Email); err != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to send password reset email", "error", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "if the address has an account, a password reset email has been sent"})
+}
+
+// ResetPassword sets a new password with the token from a reset email.
+func (h *AccountHandler) ResetPassword(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := services.ResetPassword(db, req.Token, req.Password)
+	if err != nil {
+		if err == services.ErrInvalidAccountToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	auditChanges(c, db, services.AuditPasswordReset, services.AuditTargetUser, user.ID, []models.AuditChange{{Field: "password"}})
+
+	c.JSON(http.StatusOK, gin.H{"message": "password has been reset"})
+}
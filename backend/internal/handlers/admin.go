@@ -5,13 +5,24 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"backend/internal/models"
-	"backend/internal/database"
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
+	"gorm.io/gorm"
 )
 
-func ListUsers(c *gin.Context) {
+// AdminHandler lets admins manage user accounts.
+type AdminHandler struct {
+	db *gorm.DB
+}
+
+func NewAdminHandler(db *gorm.DB) *AdminHandler {
+	return &AdminHandler{db: db}
+}
+
+func (h *AdminHandler) ListUsers(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	var users []models.User
-	query := database.DB.Model(&models.User{})
+	query := db.Model(&models.User{})
 
 	// Pagination
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -34,13 +45,15 @@ func ListUsers(c *gin.Context) {
 	})
 }
 
-func UpdateUser(c *gin.Context) {
+func (h *AdminHandler) UpdateUser(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	id := c.Param("id")
 	var user models.User
-	if err := database.DB.First(&user, id).Error; err != nil {
+	if err := db.First(&user, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
+	before := user
 
 	var updateData struct {
 		Username string `json:"username"`
@@ -54,6 +67,13 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
+	if updateData.Role != "" {
+		if err := services.ValidateRole(updateData.Role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	// Update fields if provided
 	if updateData.Username != "" {
 		user.Username = updateData.Username
@@ -68,30 +88,47 @@ func UpdateUser(c *gin.Context) {
 			return
 		}
 	}
+	roleChanged := updateData.Role != "" && updateData.Role != user.Role
 	if updateData.Role != "" {
 		user.Role = updateData.Role
 	}
 
-	if err := database.DB.Save(&user).Error; err != nil {
+	if err := db.Save(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
 
+	// Make the user log in again with the new password or role, and
+	// create new tokens
+	if updateData.Password != "" || roleChanged {
+		services.RevokeUserAccess(db, user.ID)
+	}
+
+	changes := services.AuditChanges(before, user)
+	if updateData.Password != "" {
+		// The hash is never recorded, only that it changed
+		changes = append(changes, models.AuditChange{Field: "password"})
+	}
+	auditChanges(c, db, services.AuditUserUpdate, services.AuditTargetUser, user.ID, changes)
+
 	c.JSON(http.StatusOK, user)
 }
 
-func DeleteUser(c *gin.Context) {
+func (h *AdminHandler) DeleteUser(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	id := c.Param("id")
 	var user models.User
-	if err := database.DB.First(&user, id).Error; err != nil {
+	if err := db.First(&user, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	if err := database.DB.Delete(&user).Error; err != nil {
+	if err := db.Delete(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
 	}
+	services.RevokeUserAccess(db, user.ID)
+	audit(c, db, services.AuditUserDelete, services.AuditTargetUser, user.ID, user, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
-} 
\ No newline at end of file
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
+	"gorm.io/gorm"
+)
+
+type APITokenHandler struct {
+	db *gorm.DB
+}
+
+func NewAPITokenHandler(db *gorm.DB) *APITokenHandler {
+	return &APITokenHandler{db: db}
+}
+
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"omitempty,min=1,max=365"` // defaults to 30
+}
+
+// CreateToken issues a personal access token. The token itself is in the
+// response only once.
+func (h *APITokenHandler) CreateToken(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u := c.MustGet("user").(*models.User)
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	token, plain, err := services.CreateAPIToken(db, u, req.Name, req.Scopes, ttl)
+	if err != nil {
+		if err == services.ErrUnknownScope || err == services.ErrNoScopes {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit(c, db, services.AuditAPITokenCreate, services.AuditTargetUser, u.ID, nil, token)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":     plain,
+		"api_token": token,
+	})
+}
+
+// ListTokens lists the current user's tokens that are not revoked.
+func (h *APITokenHandler) ListTokens(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	u := c.MustGet("user").(*models.User)
+
+	var tokens []models.APIToken
+	if err := db.Where("user_id = ? AND revoked_at IS NULL", u.ID).
+		Order("created_at DESC").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RevokeToken revokes one of the current user's tokens.
+func (h *APITokenHandler) RevokeToken(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token id"})
+		return
+	}
+
+	u := c.MustGet("user").(*models.User)
+	if err := services.RevokeAPIToken(db, u.ID, uint(id)); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	auditChanges(c, db, services.AuditAPITokenRevoke, services.AuditTargetUser, u.ID,
+		[]models.AuditChange{{Field: "token_id", From: id, To: nil}})
+
+	c.JSON(http.StatusOK, gin.H{"message": "token revoked"})
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
+	"gorm.io/gorm"
+)
+
+// audit records action on a target with the fields that changed between
+// before and after. The actor is the signed-in user; actions taken without
+// one, such as password resets, are attributed to the user they act on.
+func audit(c *gin.Context, db *gorm.DB, action, targetType string, targetID uint, before, after interface{}) {
+	auditChanges(c, db, action, targetType, targetID, services.AuditChanges(before, after))
+}
+
+// auditChanges is audit for changes that cannot be read off a record.
+func auditChanges(c *gin.Context, db *gorm.DB, action, targetType string, targetID uint, changes []models.AuditChange) {
+	entry := models.AuditLog{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Changes:    changes,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}
+	if u, ok := c.Get("user"); ok {
+		id := u.(*models.User).ID
+		entry.ActorID = &id
+	} else if targetType == services.AuditTargetUser {
+		entry.ActorID = &targetID
+	}
+	services.RecordAudit(db, &entry)
+}
+
+// AuditHandler lets admins search and export the audit log.
+type AuditHandler struct {
+	db *gorm.DB
+}
+
+func NewAuditHandler(db *gorm.DB) *AuditHandler {
+	return &AuditHandler{db: db}
+}
+
+// auditFilter reads the filters shared by ListAudit and ExportAudit:
+//
+//	actor_id            user who acted
+//	action              exact action, or a target type for all of its actions
+//	target_type         user, problem or contest
+//	target_id           id of the target
+//	ip                  client IP
+//	since, until        RFC 3339 times, since inclusive
+func auditFilter(c *gin.Context) (services.AuditFilter, error) {
+	filter := services.AuditFilter{
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		IP:         c.Query("ip"),
+	}
+
+	for param, id := range map[string]**uint{"actor_id": &filter.ActorID, "target_id": &filter.TargetID} {
+		if v := c.Query(param); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s", param)
+			}
+			u := uint(n)
+			*id = &u
+		}
+	}
+
+	for param, t := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := c.Query(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s, expected an RFC 3339 time", param)
+			}
+			*t = &parsed
+		}
+	}
+
+	return filter, nil
+}
+
+// ListAudit lists audit entries newest first. Besides the auditFilter
+// filters it takes limit (default 50, at most 200) and cursor, the
+// next_cursor of the previous page.
+func (h *AuditHandler) ListAudit(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	filter, err := auditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	query := filter.Query(db).Preload("Actor")
+	if v := c.Query("cursor"); v != "" {
+		cursor, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidCursor.Error()})
+			return
+		}
+		query = query.Where("id < ?", cursor)
+	}
+
+	var entries []models.AuditLog
+	if err := query.Limit(limit + 1).Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load audit log"})
+		return
+	}
+
+	response := gin.H{"entries": entries, "limit": limit}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		response["entries"] = entries
+		response["next_cursor"] = strconv.FormatUint(uint64(entries[limit-1].ID), 10)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ExportAudit streams every entry matching the auditFilter filters as CSV
+// or, with format=json, as one JSON object per line.
+func (h *AuditHandler) ExportAudit(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	filter, err := auditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or json"})
+		return
+	}
+
+	filename := "audit-" + time.Now().UTC().Format("20060102-150405")
+	var write func(entry *models.AuditLog) error
+	var flush func()
+	if format == "json" {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.jsonl"`)
+		enc := json.NewEncoder(c.Writer)
+		write = func(entry *models.AuditLog) error { return enc.Encode(entry) }
+		flush = func() {}
+	} else {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"id", "created_at", "actor_id", "actor", "action", "target_type", "target_id", "ip", "user_agent", "changes"})
+		write = func(entry *models.AuditLog) error {
+			var actorID, actor string
+			if entry.ActorID != nil {
+				actorID = strconv.FormatUint(uint64(*entry.ActorID), 10)
+			}
+			if entry.Actor != nil {
+				actor = entry.Actor.Username
+			}
+			changes, _ := json.Marshal(entry.Changes)
+			return w.Write([]string{
+				strconv.FormatUint(uint64(entry.ID), 10),
+				entry.CreatedAt.UTC().Format(time.RFC3339),
+				actorID,
+				actor,
+				entry.Action,
+				entry.TargetType,
+				strconv.FormatUint(uint64(entry.TargetID), 10),
+				entry.IP,
+				entry.UserAgent,
+				string(changes),
+			})
+		}
+		flush = w.Flush
+	}
+	c.Status(http.StatusOK)
+
+	// Page through newest first. Headers are sent by now, so a failure can
+	// only cut the export short.
+	var cursor uint
+	for {
+		query := filter.Query(db).Preload("Actor")
+		if cursor != 0 {
+			query = query.Where("id < ?", cursor)
+		}
+		var entries []models.AuditLog
+		if err := query.Limit(500).Find(&entries).Error; err != nil || len(entries) == 0 {
+			break
+		}
+		for i := range entries {
+			if err := write(&entries[i]); err != nil {
+				return
+			}
+		}
+		flush()
+		cursor = entries[len(entries)-1].ID
+	}
+}
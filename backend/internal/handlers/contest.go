@@ -6,8 +6,9 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"backend/internal/models"
-	"backend/internal/database"
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
+	"gorm.io/gorm"
 )
 
 type CreateContestRequest struct {
@@ -17,17 +18,33 @@ type CreateContestRequest struct {
 	EndTime     time.Time `json:"end_time" binding:"required"`
 	IsPublic    bool      `json:"is_public"`
 	ProblemIDs  []uint    `json:"problem_ids" binding:"required,min=1"`
+
+	// Limits on submissions made in the contest, none when nil
+	Policy *models.SubmissionPolicy `json:"policy"`
+}
+
+type ContestHandler struct {
+	db *gorm.DB
 }
 
-func CreateContest(c *gin.Context) {
+func NewContestHandler(db *gorm.DB) *ContestHandler {
+	return &ContestHandler{db: db}
+}
+
+func (h *ContestHandler) CreateContest(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	var req CreateContestRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	user, _ := c.Get("user")
-	u := user.(models.User)
+	if err := services.ValidatePolicy(req.Policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u := c.MustGet("user").(*models.User)
 
 	contest := models.Contest{
 		Title:       req.Title,
@@ -35,38 +52,45 @@ func CreateContest(c *gin.Context) {
 		StartTime:   req.StartTime,
 		EndTime:     req.EndTime,
 		IsPublic:    req.IsPublic,
-		CreatorID:   u.ID,
+		Policy:      req.Policy,
+		CreatedBy:   u.ID,
 	}
 
 	// Associate problems
 	var problems []models.Problem
-	if err := database.DB.Where("id IN ?", req.ProblemIDs).Find(&problems).Error; err != nil {
+	if err := db.Where("id IN ?", req.ProblemIDs).Find(&problems).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid problem IDs"})
 		return
 	}
 	contest.Problems = problems
 
-	if err := database.DB.Create(&contest).Error; err != nil {
+	if err := db.Create(&contest).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create contest"})
 		return
 	}
+	audit(c, db, services.AuditContestCreate, services.ResourceContest, contest.ID, nil, services.AuditContest(&contest))
 
 	c.JSON(http.StatusCreated, contest)
 }
 
-func GetContest(c *gin.Context) {
+func (h *ContestHandler) GetContest(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	id := c.Param("id")
 	var contest models.Contest
-	if err := database.DB.Preload("Problems").Preload("Users").First(&contest, id).Error; err != nil {
+	if err := db.Preload("Problems").Preload("Users").First(&contest, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Contest not found"})
 		return
 	}
+	for i := range contest.Problems {
+		hideTests(&contest.Problems[i])
+	}
 	c.JSON(http.StatusOK, contest)
 }
 
-func ListContests(c *gin.Context) {
+func (h *ContestHandler) ListContests(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	var contests []models.Contest
-	query := database.DB.Model(&models.Contest{})
+	query := db.Model(&models.Contest{})
 
 	if isPublic := c.Query("is_public"); isPublic != "" {
 		if isPublic == "true" {
@@ -93,6 +117,11 @@ func ListContests(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch contests"})
 		return
 	}
+	for i := range contests {
+		for j := range contests[i].Problems {
+			hideTests(&contests[i].Problems[j])
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"contests": contests,
@@ -102,10 +131,11 @@ func ListContests(c *gin.Context) {
 	})
 }
 
-func RegisterForContest(c *gin.Context) {
+func (h *ContestHandler) RegisterForContest(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	id := c.Param("id")
 	var contest models.Contest
-	if err := database.DB.First(&contest, id).Error; err != nil {
+	if err := db.First(&contest, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Contest not found"})
 		return
 	}
@@ -120,10 +150,9 @@ func RegisterForContest(c *gin.Context) {
 		return
 	}
 
-	user, _ := c.Get("user")
-	u := user.(models.User)
+	u := c.MustGet("user").(*models.User)
 
-	if err := database.DB.Model(&contest).Association("Users").Append(&u); err != nil {
+	if err := db.Model(&contest).Association("Users").Append(u); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register for contest"})
 		return
 	}
@@ -131,68 +160,66 @@ func RegisterForContest(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Registered successfully"})
 }
 
-func UpdateContest(c *gin.Context) {
+func (h *ContestHandler) UpdateContest(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	id := c.Param("id")
 	var contest models.Contest
-	if err := database.DB.Preload("Problems").First(&contest, id).Error; err != nil {
+	if err := db.Preload("Problems").First(&contest, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Contest not found"})
 		return
 	}
 
-	user, _ := c.Get("user")
-	u := user.(models.User)
-	if contest.CreatorID != u.ID && u.Role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to update this contest"})
+	var req CreateContestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var req CreateContestRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := services.ValidatePolicy(req.Policy); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	before := services.AuditContest(&contest)
+
 	contest.Title = req.Title
 	contest.Description = req.Description
 	contest.StartTime = req.StartTime
 	contest.EndTime = req.EndTime
 	contest.IsPublic = req.IsPublic
+	contest.Policy = req.Policy
 
 	// Update problems
 	var problems []models.Problem
-	if err := database.DB.Where("id IN ?", req.ProblemIDs).Find(&problems).Error; err != nil {
+	if err := db.Where("id IN ?", req.ProblemIDs).Find(&problems).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid problem IDs"})
 		return
 	}
-	database.DB.Model(&contest).Association("Problems").Replace(problems)
+	db.Model(&contest).Association("Problems").Replace(problems)
 
-	if err := database.DB.Save(&contest).Error; err != nil {
+	if err := db.Save(&contest).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update contest"})
 		return
 	}
+	audit(c, db, services.AuditContestUpdate, services.ResourceContest, contest.ID, before, services.AuditContest(&contest))
 
 	c.JSON(http.StatusOK, contest)
 }
 
-func DeleteContest(c *gin.Context) {
+func (h *ContestHandler) DeleteContest(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	id := c.Param("id")
 	var contest models.Contest
-	if err := database.DB.First(&contest, id).Error; err != nil {
+	if err := db.Preload("Problems").First(&contest, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Contest not found"})
 		return
 	}
 
-	user, _ := c.Get("user")
-	u := user.(models.User)
-	if contest.CreatorID != u.ID && u.Role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to delete this contest"})
-		return
-	}
-
-	if err := database.DB.Delete(&contest).Error; err != nil {
+	if err := db.Delete(&contest).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete contest"})
 		return
 	}
+	audit(c, db, services.AuditContestDelete, services.ResourceContest, contest.ID, services.AuditContest(&contest), nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Contest deleted successfully"})
-} 
\ No newline at end of file
+}
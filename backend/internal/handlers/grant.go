@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
+	"gorm.io/gorm"
+)
+
+// GrantHandler manages per-resource roles on one type of resource, such as
+// co-authors of a problem or co-managers of a contest. Routes must be
+// guarded by RequireOn, which stores the resource in the context.
+type GrantHandler struct {
+	db           *gorm.DB
+	resourceType string
+}
+
+func NewGrantHandler(db *gorm.DB, resourceType string) *GrantHandler {
+	return &GrantHandler{db: db, resourceType: resourceType}
+}
+
+// auditAction picks the problem or contest variant of an audited action.
+func (h *GrantHandler) auditAction(problemAction, contestAction string) string {
+	if h.resourceType == services.ResourceContest {
+		return contestAction
+	}
+	return problemAction
+}
+
+// grantAudit is what the audit log records of a grant, keyed by user so
+// that a role change still names whose role it was.
+func grantAudit(userID uint, role string) gin.H {
+	return gin.H{"user_" + strconv.FormatUint(uint64(userID), 10): role}
+}
+
+type GrantRequest struct {
+	Username string `json:"username" binding:"required"`
+	Role     string `json:"role" binding:"required"`
+}
+
+// ListGrants lists who holds a role on the resource, owner first.
+func (h *GrantHandler) ListGrants(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	resource := c.MustGet("resource").(*services.Resource)
+
+	var grants []models.ResourceGrant
+	if err := db.Preload("User").
+		Where("resource_type = ? AND resource_id = ?", resource.Type, resource.ID).
+		Order("created_at").Find(&grants).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"owner_id": resource.OwnerID,
+		"grants":   grants,
+	})
+}
+
+// AddGrant gives a user a role on the resource, replacing any role they
+// already held there.
+func (h *GrantHandler) AddGrant(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	resource := c.MustGet("resource").(*services.Resource)
+
+	var req GrantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateGrantRole(h.resourceType, req.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", strings.TrimSpace(req.Username)).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if user.ID == resource.OwnerID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user already owns this " + h.resourceType})
+		return
+	}
+
+	u := c.MustGet("user").(*models.User)
+	grant := models.ResourceGrant{
+		ResourceType: resource.Type,
+		ResourceID:   resource.ID,
+		UserID:       user.ID,
+	}
+	var before interface{}
+	var existing models.ResourceGrant
+	if db.Where(grant).First(&existing).Error == nil {
+		before = grantAudit(existing.UserID, existing.Role)
+	}
+	err := db.Where(grant).
+		Assign(models.ResourceGrant{Role: req.Role, CreatedBy: u.ID}).
+		FirstOrCreate(&grant).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit(c, db, h.auditAction(services.AuditProblemGrantAdd, services.AuditContestGrantAdd),
+		resource.Type, resource.ID, before, grantAudit(grant.UserID, grant.Role))
+
+	grant.User = user
+	c.JSON(http.StatusOK, grant)
+}
+
+// RemoveGrant takes away a user's role on the resource.
+func (h *GrantHandler) RemoveGrant(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	resource := c.MustGet("resource").(*services.Resource)
+
+	var grant models.ResourceGrant
+	if err := db.Where("resource_type = ? AND resource_id = ? AND user_id = ?", resource.Type, resource.ID, c.Param("user")).
+		First(&grant).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "grant not found"})
+		return
+	}
+	if err := db.Delete(&grant).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit(c, db, h.auditAction(services.AuditProblemGrantRemove, services.AuditContestGrantRemove),
+		resource.Type, resource.ID, grantAudit(grant.UserID, grant.Role), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "grant removed"})
+}
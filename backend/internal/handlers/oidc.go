@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/onlinejudge/backend/internal/services"
+	"github.com/onlinejudge/backend/pkg/logging"
+	"gorm.io/gorm"
+)
+
+// OIDCHandler logs users in through OpenID Connect identity providers with
+// the authorization code flow and PKCE.
+type OIDCHandler struct {
+	db        *gorm.DB
+	providers map[string]*services.OIDCProvider
+}
+
+func NewOIDCHandler(db *gorm.DB, providers map[string]*services.OIDCProvider) *OIDCHandler {
+	return &OIDCHandler{db: db, providers: providers}
+}
+
+// ListProviders lists the identity providers the login page can offer.
+func (h *OIDCHandler) ListProviders(c *gin.Context) {
+	providers := make([]*services.OIDCProvider, 0, len(h.providers))
+	for _, p := range h.providers {
+		providers = append(providers, p)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+
+	c.JSON(http.StatusOK, providers)
+}
+
+// safeReturnTo keeps the post-login redirect on the frontend.
+func safeReturnTo(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
+
+// Login sends the browser to the identity provider.
+func (h *OIDCHandler) Login(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrUnknownProvider.Error()})
+		return
+	}
+
+	authURL, err := services.StartOIDCLogin(c.Request.Context(), db, provider, safeReturnTo(c.Query("return_to")))
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("OIDC login failed", "provider", provider.Name, "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider is unavailable"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback finishes a login when the identity provider sends the browser
+// back, then hands the new session's tokens to the frontend.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrUnknownProvider.Error()})
+		return
+	}
+
+	fail := func(message string) {
+		c.Redirect(http.StatusFound, services.LoginRedirectURL(url.Values{"error": {message}}))
+	}
+
+	if e := c.Query("error"); e != "" {
+		fail(e)
+		return
+	}
+
+	user, returnTo, err := services.FinishOIDCLogin(c.Request.Context(), db, provider, c.Query("code"), c.Query("state"))
+	if err != nil {
+		switch err {
+		case services.ErrInvalidLoginState, services.ErrEmailUnverified:
+			fail(err.Error())
+		default:
+			logging.FromContext(c.Request.Context()).Error("OIDC callback failed", "provider", provider.Name, "error", err)
+			fail("login failed")
+		}
+		return
+	}
+
+	// Two-factor users finish with POST /auth/2fa/verify like a password login
+	if services.TwoFactorEnabled(db, user.ID) {
+		challenge, err := services.StartLoginChallenge(db, user)
+		if err != nil {
+			fail("login failed")
+			return
+		}
+		c.Redirect(http.StatusFound, services.LoginRedirectURL(url.Values{
+			"two_factor_required": {"true"},
+			"challenge":           {challenge},
+			"return_to":           {returnTo},
+		}))
+		return
+	}
+
+	tokens, err := services.StartSession(db, user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		fail("login failed")
+		return
+	}
+
+	c.Redirect(http.StatusFound, services.LoginRedirectURL(url.Values{
+		"access_token":  {tokens.AccessToken},
+		"refresh_token": {tokens.RefreshToken},
+		"token_type":    {tokens.TokenType},
+		"expires_in":    {strconv.Itoa(tokens.ExpiresIn)},
+		"return_to":     {returnTo},
+	}))
+}
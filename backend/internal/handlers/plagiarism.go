@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
+	"gorm.io/gorm"
+)
+
+type PlagiarismHandler struct {
+	db *gorm.DB
+}
+
+func NewPlagiarismHandler(db *gorm.DB) *PlagiarismHandler {
+	return &PlagiarismHandler{db: db}
+}
+
+type PlagiarismRequest struct {
+	Threshold *float64 `json:"threshold" binding:"omitempty,max=1"`
+}
+
+// loadContest fetches the contest from the route. Access is checked by the
+// route's RequireOn. It writes the error response itself.
+func (h *PlagiarismHandler) loadContest(c *gin.Context) (*models.Contest, bool) {
+	db := h.db.WithContext(c.Request.Context())
+	var contest models.Contest
+	if err := db.First(&contest, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "contest not found"})
+		return nil, false
+	}
+
+	return &contest, true
+}
+
+// StartCheck queues a plagiarism check over the contest's accepted
+// submissions. The report is filled in asynchronously.
+func (h *PlagiarismHandler) StartCheck(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	contest, ok := h.loadContest(c)
+	if !ok {
+		return
+	}
+
+	var req PlagiarismRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	report := models.PlagiarismReport{
+		ContestID: contest.ID,
+		Status:    "pending",
+		Threshold: services.DefaultPlagiarismThreshold,
+		CreatedBy: c.MustGet("user").(*models.User).ID,
+	}
+	if req.Threshold != nil {
+		if *req.Threshold < services.MinPlagiarismThreshold {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("threshold must be at least %g", services.MinPlagiarismThreshold)})
+			return
+		}
+		report.Threshold = *req.Threshold
+	}
+
+	if err := db.Create(&report).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The check outlives the request, so it must not inherit its context
+	services.StartPlagiarismCheck(h.db, &report)
+	auditChanges(c, db, services.AuditPlagiarismCheck, services.ResourceContest, contest.ID, []models.AuditChange{
+		{Field: "report_id", To: report.ID},
+		{Field: "threshold", To: report.Threshold},
+	})
+
+	c.JSON(http.StatusAccepted, report)
+}
+
+func (h *PlagiarismHandler) ListReports(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	contest, ok := h.loadContest(c)
+	if !ok {
+		return
+	}
+
+	var reports []models.PlagiarismReport
+	if err := db.Where("contest_id = ?", contest.ID).Order("id DESC").Find(&reports).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, reports)
+}
+
+// GetReport returns a report with its pairs ranked by similarity.
+func (h *PlagiarismHandler) GetReport(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	contest, ok := h.loadContest(c)
+	if !ok {
+		return
+	}
+
+	var report models.PlagiarismReport
+	if err := db.Preload("Pairs", func(db *gorm.DB) *gorm.DB {
+		return db.Order("similarity DESC")
+	}).Where("contest_id = ?", contest.ID).First(&report, c.Param("report")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "report not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetPair returns both sources of a pair for side-by-side review, with the
+// matching line ranges to highlight.
+func (h *PlagiarismHandler) GetPair(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	contest, ok := h.loadContest(c)
+	if !ok {
+		return
+	}
+
+	var pair models.PlagiarismPair
+	reports := db.Model(&models.PlagiarismReport{}).Select("id").Where("contest_id = ?", contest.ID)
+	if err := db.Where("id = ? AND report_id = ? AND report_id IN (?)", c.Param("pair"), c.Param("report"), reports).
+		First(&pair).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "pair not found"})
+		return
+	}
+
+	var a, b models.Submission
+	if err := db.Preload("User").First(&a, pair.SubmissionA).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "submission not found"})
+		return
+	}
+	if err := db.Preload("User").First(&b, pair.SubmissionB).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "submission not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pair": pair,
+		"a": gin.H{
+			"submission_id": a.ID,
+			"username":      a.User.Username,
+			"source":        services.SubmissionSource(&a),
+		},
+		"b": gin.H{
+			"submission_id": b.ID,
+			"username":      b.User.Username,
+			"source":        services.SubmissionSource(&b),
+		},
+	})
+}
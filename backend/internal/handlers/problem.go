@@ -3,134 +3,552 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/onlinejudge/backend/internal/models"
-	"github.com/onlinejudge/backend/pkg/database"
+	"github.com/onlinejudge/backend/internal/services"
+	"github.com/onlinejudge/backend/pkg/broker"
+	"gorm.io/gorm"
 )
 
 type CreateProblemRequest struct {
 	Title       string `json:"title" binding:"required"`
-	Description string `json:"description" binding:"required"`
+	Description string `json:"description"` // plain summary, defaults to the first statement's legend
 	Difficulty  string `json:"difficulty" binding:"required"`
+	Points      int    `json:"points"`
 	TimeLimit   int    `json:"time_limit" binding:"required"`
 	MemoryLimit int    `json:"memory_limit" binding:"required"`
+	Checker     string `json:"checker"`
+	IOMode      string `json:"io_mode"` // stdio (default), files or output_only
+	InputFile   string `json:"input_file"`
+	OutputFile  string `json:"output_file"`
+	Message     string `json:"message"` // revision note
 	TestCases   []struct {
 		Input    string `json:"input" binding:"required"`
 		Output   string `json:"output" binding:"required"`
 		IsSample bool   `json:"is_sample"`
-	} `json:"test_cases" binding:"required,min=1"`
-	Tags []string `json:"tags"`
+	} `json:"test_cases" binding:"dive"`
+	Tags       []string           `json:"tags"`
+	Statements []StatementRequest `json:"statements" binding:"dive"`
+
+	// Authoring programs; generated tests are appended after test_cases
+	Validator  *ProgramRequest  `json:"validator"`
+	Generators []ProgramRequest `json:"generators" binding:"dive"`
+	Solutions  []ProgramRequest `json:"solutions" binding:"dive"` // reference solutions, see models.SolutionExpectations
+	TestScript string           `json:"test_script"`
+
+	// Function-style problems: users implement Signature and each harness
+	// turns their function into a program for one language
+	Signature *models.FunctionSignature `json:"signature"`
+	Harnesses []HarnessRequest          `json:"harnesses" binding:"dive"`
+
+	// Limits on submissions to the problem, none when nil
+	Policy *models.SubmissionPolicy `json:"policy"`
 }
 
-func CreateProblem(c *gin.Context) {
+type ProgramRequest struct {
+	Name     string `json:"name"`
+	Language string `json:"language" binding:"required"`
+	Source   string `json:"source" binding:"required"`
+	Expected string `json:"expected"` // solutions only
+}
+
+type HarnessRequest struct {
+	Language string `json:"language" binding:"required"`
+	Template string `json:"template"` // starter code
+	Harness  string `json:"harness" binding:"required"`
+}
+
+// StatementRequest is one translation of the problem statement.
+type StatementRequest struct {
+	Language     string `json:"language" binding:"required"`
+	Title        string `json:"title" binding:"required"`
+	Legend       string `json:"legend"`
+	InputFormat  string `json:"input_format"`
+	OutputFormat string `json:"output_format"`
+	Notes        string `json:"notes"`
+	Scoring      string `json:"scoring"`
+}
+
+func (p *ProgramRequest) program(kind string) models.ProblemProgram {
+	return models.ProblemProgram{
+		Kind:     kind,
+		Name:     p.Name,
+		Language: p.Language,
+		Source:   p.Source,
+		Expected: p.Expected,
+	}
+}
+
+// testCases converts the request's test cases into unsaved models.
+func (r *CreateProblemRequest) testCases() []models.TestCase {
+	tests := make([]models.TestCase, 0, len(r.TestCases))
+	for _, tc := range r.TestCases {
+		tests = append(tests, models.TestCase{
+			Input:    tc.Input,
+			Output:   tc.Output,
+			IsSample: tc.IsSample,
+		})
+	}
+	return tests
+}
+
+// statements converts and validates the request's translations. It writes
+// the error response itself.
+func (r *CreateProblemRequest) statements(c *gin.Context) ([]models.ProblemStatement, bool) {
+	statements := make([]models.ProblemStatement, 0, len(r.Statements))
+	seen := make(map[string]bool)
+	for _, st := range r.Statements {
+		statement := models.ProblemStatement{
+			Language:     st.Language,
+			Title:        st.Title,
+			Legend:       st.Legend,
+			InputFormat:  st.InputFormat,
+			OutputFormat: st.OutputFormat,
+			Notes:        st.Notes,
+			Scoring:      st.Scoring,
+		}
+		if seen[statement.Language] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Duplicate statement language " + statement.Language})
+			return nil, false
+		}
+		seen[statement.Language] = true
+		if err := services.ValidateStatement(&statement); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		statements = append(statements, statement)
+	}
+
+	if r.Description == "" {
+		if len(statements) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Either a description or a statement is required"})
+			return nil, false
+		}
+		r.Description = statements[0].Legend
+	}
+
+	return statements, true
+}
+
+// harnesses converts and validates the request's function signature
+// harnesses. It writes the error response itself.
+func (r *CreateProblemRequest) harnesses(c *gin.Context) ([]models.ProblemHarness, bool) {
+	if r.Signature == nil {
+		if len(r.Harnesses) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Harnesses require a function signature"})
+			return nil, false
+		}
+		return nil, true
+	}
+
+	if err := services.ValidateSignature(r.Signature); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if len(r.Harnesses) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Function-style problems need at least one harness"})
+		return nil, false
+	}
+
+	harnesses := make([]models.ProblemHarness, 0, len(r.Harnesses))
+	seen := make(map[string]bool)
+	for _, h := range r.Harnesses {
+		harness := models.ProblemHarness{
+			Language: h.Language,
+			Template: h.Template,
+			Harness:  h.Harness,
+		}
+		if seen[harness.Language] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Duplicate harness language " + harness.Language})
+			return nil, false
+		}
+		seen[harness.Language] = true
+		if err := services.ValidateHarness(&harness, r.Signature); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		harnesses = append(harnesses, harness)
+	}
+
+	return harnesses, true
+}
+
+// programs converts the request's authoring programs into unsaved models.
+func (r *CreateProblemRequest) programs() []models.ProblemProgram {
+	var programs []models.ProblemProgram
+	if r.Validator != nil {
+		programs = append(programs, r.Validator.program(models.ProgramValidator))
+	}
+	for i := range r.Generators {
+		programs = append(programs, r.Generators[i].program(models.ProgramGenerator))
+	}
+	for i := range r.Solutions {
+		programs = append(programs, r.Solutions[i].program(models.ProgramSolution))
+	}
+	return programs
+}
+
+// checkTestSet checks the request's authoring programs and runs the
+// validator against its hand-written tests, which it returns. Tests from the
+// test script are left to the judge, see services.PrepareTests; generate
+// reports whether there are any. It writes the error response itself.
+func checkTestSet(c *gin.Context, evaluator *services.Evaluator, req *CreateProblemRequest) (tests []models.TestCase, generate bool, ok bool) {
+	names := make(map[string]bool)
+	for i := range req.Generators {
+		name := req.Generators[i].Name
+		if name == "" || names[name] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Generators must have unique non-empty names"})
+			return nil, false, false
+		}
+		names[name] = true
+	}
+
+	// The main solution produces the answers of generated tests
+	hasMain := false
+	for i := range req.Solutions {
+		program := req.Solutions[i].program(models.ProgramSolution)
+		if program.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Solutions must have a name"})
+			return nil, false, false
+		}
+		if _, ok := models.SolutionExpectations[program.Expected]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown expected outcome for solution " + program.Name})
+			return nil, false, false
+		}
+		if program.Expected == models.ExpectMain {
+			if hasMain {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Only one solution can be tagged main"})
+				return nil, false, false
+			}
+			hasMain = true
+		}
+	}
+
+	script := strings.TrimSpace(req.TestScript) != ""
+	if script && !hasMain {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A main solution is required to produce answers for generated tests"})
+		return nil, false, false
+	}
+
+	tests = req.testCases()
+	if len(tests) == 0 && !script {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one test case is required"})
+		return nil, false, false
+	}
+
+	if req.Validator != nil && len(tests) > 0 {
+		validator := req.Validator.program(models.ProgramValidator)
+		if err := evaluator.ValidateTests(&validator, tests); err != nil {
+			if verr, ok := err.(*services.ValidationError); ok {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": verr.Error(), "test": verr.Test, "message": verr.Message})
+				return nil, false, false
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false, false
+		}
+	}
+
+	return tests, script, true
+}
+
+// saveProblemPrograms replaces the authoring programs stored for a problem.
+func saveProblemPrograms(tx *gorm.DB, problemID uint, programs []models.ProblemProgram) error {
+	if err := tx.Where("problem_id = ?", problemID).Delete(&models.ProblemProgram{}).Error; err != nil {
+		return err
+	}
+	for i := range programs {
+		programs[i].ProblemID = problemID
+	}
+	if len(programs) == 0 {
+		return nil
+	}
+	return tx.Create(&programs).Error
+}
+
+// saveProblemHarnesses replaces the function harnesses of a problem.
+func saveProblemHarnesses(tx *gorm.DB, problemID uint, harnesses []models.ProblemHarness) error {
+	if err := tx.Where("problem_id = ?", problemID).Delete(&models.ProblemHarness{}).Error; err != nil {
+		return err
+	}
+	for i := range harnesses {
+		harnesses[i].ProblemID = problemID
+	}
+	if len(harnesses) == 0 {
+		return nil
+	}
+	return tx.Create(&harnesses).Error
+}
+
+type ProblemHandler struct {
+	db        *gorm.DB
+	broker    *broker.NATSClient
+	evaluator *services.Evaluator // runs the validator on hand-written tests in the request
+}
+
+func NewProblemHandler(db *gorm.DB, broker *broker.NATSClient, evaluator *services.Evaluator) *ProblemHandler {
+	return &ProblemHandler{
+		db:        db,
+		broker:    broker,
+		evaluator: evaluator,
+	}
+}
+
+func (h *ProblemHandler) CreateProblem(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	var req CreateProblemRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	user, _ := c.Get("user")
-	u := user.(models.User)
+	u := c.MustGet("user").(*models.User)
+
+	if !services.SupportedCheckers[req.Checker] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported checker"})
+		return
+	}
+
+	if err := services.ValidateIOMode(req.IOMode, req.InputFile, req.OutputFile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ValidatePolicy(req.Policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	statements, ok := req.statements(c)
+	if !ok {
+		return
+	}
+
+	harnesses, ok := req.harnesses(c)
+	if !ok {
+		return
+	}
+
+	tests, generate, ok := checkTestSet(c, h.evaluator, &req)
+	if !ok {
+		return
+	}
 
 	// Create problem
 	problem := models.Problem{
 		Title:       req.Title,
 		Description: req.Description,
 		Difficulty:  req.Difficulty,
+		Points:      req.Points,
 		TimeLimit:   req.TimeLimit,
 		MemoryLimit: req.MemoryLimit,
+		Checker:     req.Checker,
+		IOMode:      req.IOMode,
+		InputFile:   req.InputFile,
+		OutputFile:  req.OutputFile,
+		TestScript:  req.TestScript,
+		Signature:   req.Signature,
+		Policy:      req.Policy,
 		CreatedBy:   u.ID,
 	}
 
-	// Create test cases
-	for _, tc := range req.TestCases {
-		problem.TestCases = append(problem.TestCases, models.TestCase{
-			Input:    tc.Input,
-			Output:   tc.Output,
-			IsSample: tc.IsSample,
-		})
-	}
-
 	// Create or get tags
 	for _, tagName := range req.Tags {
 		var tag models.Tag
-		database.DB.FirstOrCreate(&tag, models.Tag{Name: tagName})
+		db.FirstOrCreate(&tag, models.Tag{Name: tagName})
 		problem.Tags = append(problem.Tags, tag)
 	}
 
-	if err := database.DB.Create(&problem).Error; err != nil {
+	message := req.Message
+	if message == "" {
+		message = "Initial revision"
+	}
+
+	// Create the problem and its first revision, which owns the test cases
+	var revision *models.ProblemRevision
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&problem).Error; err != nil {
+			return err
+		}
+		if err := saveProblemPrograms(tx, problem.ID, req.programs()); err != nil {
+			return err
+		}
+		if err := saveProblemHarnesses(tx, problem.ID, harnesses); err != nil {
+			return err
+		}
+		var err error
+		revision, err = services.CreateRevision(tx, &problem, services.RevisionContent{
+			Tests:      tests,
+			Statements: statements,
+			Harnesses:  harnesses,
+			Generate:   generate,
+		}, u.ID, message)
+		if err != nil {
+			return err
+		}
+		problem.TestCases = revision.TestCases
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create problem"})
 		return
 	}
 
+	audit(c, db, services.AuditProblemCreate, services.ResourceProblem, problem.ID, nil, services.AuditProblem(&problem, revision))
+	if !queueRevision(c, db, h.broker, revision) {
+		return
+	}
+
 	c.JSON(http.StatusCreated, problem)
 }
 
-func GetProblem(c *gin.Context) {
+func (h *ProblemHandler) GetProblem(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	id := c.Param("id")
 	var problem models.Problem
 
-	if err := database.DB.Preload("TestCases").Preload("Tags").First(&problem, id).Error; err != nil {
+	if err := db.Preload("Tags").Preload("Harnesses").First(&problem, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Problem not found"})
 		return
 	}
 
+	// Only expose the tests of the current revision
+	revision, err := services.CurrentRevision(db, &problem)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load problem revision"})
+		return
+	}
+	problem.TestCases = revision.TestCases
+	problem.Statement = selectStatement(c, revision.Statements)
+
+	// Hidden tests and the generator script are for authors and testers
+	if !h.canSeeTests(c, &problem) {
+		hideTests(&problem)
+	}
+
+	if len(problem.Harnesses) > 0 {
+		problem.StarterCode = make(map[string]string, len(problem.Harnesses))
+		for _, harness := range problem.Harnesses {
+			problem.StarterCode[harness.Language] = harness.Template
+		}
+	}
+
 	c.JSON(http.StatusOK, problem)
 }
 
-func ListProblems(c *gin.Context) {
-	var problems []models.Problem
-	query := database.DB.Model(&models.Problem{})
+// canSeeTests reports whether the signed-in user, if any, may edit or
+// verify the problem.
+func (h *ProblemHandler) canSeeTests(c *gin.Context, problem *models.Problem) bool {
+	db := h.db.WithContext(c.Request.Context())
+	user, ok := c.Get("user")
+	if !ok {
+		return false
+	}
+	u := user.(*models.User)
+	resource := &services.Resource{Type: services.ResourceProblem, ID: problem.ID, OwnerID: problem.CreatedBy}
+	return services.Can(db, u, services.PermProblemEdit, resource) || services.Can(db, u, services.PermProblemVerify, resource)
+}
 
-	// Apply filters
-	if difficulty := c.Query("difficulty"); difficulty != "" {
-		query = query.Where("difficulty = ?", difficulty)
+// hideTests strips what contestants must not see from a problem: every
+// test but the samples, and the generator script.
+func hideTests(problem *models.Problem) {
+	samples := make([]models.TestCase, 0, len(problem.TestCases))
+	for _, tc := range problem.TestCases {
+		if tc.IsSample {
+			samples = append(samples, tc)
+		}
+	}
+	problem.TestCases = samples
+	problem.TestScript = ""
+}
+
+// ListProblems searches problems. Supported query parameters:
+//
+//	q                  full-text search over titles and statements
+//	difficulty         exact difficulty
+//	tags, tag_mode     comma-separated tag names matched with "any" (default) or "all"
+//	min_points         lower bound on points, inclusive
+//	max_points         upper bound on points, inclusive
+//	author             username of the problem setter
+//	status             solved, unsolved or attempted by the current user
+//	sort, order        newest, points, solves or acceptance; desc (default) or asc
+//	cursor, limit      keyset pagination, pass next_cursor to get the next page
+func (h *ProblemHandler) ListProblems(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	filter := services.ProblemFilter{
+		Query:        c.Query("q"),
+		Difficulty:   c.Query("difficulty"),
+		MatchAllTags: c.Query("tag_mode") == "all",
+		Author:       c.Query("author"),
+		Status:       c.Query("status"),
+		Sort:         c.Query("sort"),
+		Ascending:    c.Query("order") == "asc",
+		Cursor:       c.Query("cursor"),
 	}
 
+	if tags := c.Query("tags"); tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				filter.Tags = append(filter.Tags, tag)
+			}
+		}
+	}
 	if tag := c.Query("tag"); tag != "" {
-		query = query.Joins("JOIN problem_tags ON problem_tags.problem_id = problems.id").
-			Joins("JOIN tags ON tags.id = problem_tags.tag_id").
-			Where("tags.name = ?", tag)
+		filter.Tags = append(filter.Tags, tag)
 	}
 
-	// Pagination
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	offset := (page - 1) * limit
+	for param, bound := range map[string]**int{"min_points": &filter.MinPoints, "max_points": &filter.MaxPoints} {
+		if v := c.Query(param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return
+			}
+			*bound = &n
+		}
+	}
 
-	var total int64
-	query.Count(&total)
+	filter.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
 
-	if err := query.Offset(offset).Limit(limit).Find(&problems).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch problems"})
+	if filter.Status != "" {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Login required to filter by status"})
+			return
+		}
+		filter.UserID = user.(*models.User).ID
+	}
+
+	page, err := services.SearchProblems(db, filter)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidCursor, services.ErrInvalidSort, services.ErrInvalidStatus:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch problems"})
+		}
 		return
 	}
+	for i := range page.Problems {
+		hideTests(&page.Problems[i])
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"problems": problems,
-		"total":    total,
-		"page":     page,
-		"limit":    limit,
-	})
+	c.JSON(http.StatusOK, page)
 }
 
-func UpdateProblem(c *gin.Context) {
+func (h *ProblemHandler) UpdateProblem(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	id := c.Param("id")
 	var problem models.Problem
 
-	if err := database.DB.First(&problem, id).Error; err != nil {
+	if err := db.First(&problem, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Problem not found"})
 		return
 	}
 
-	// Check if user is the creator or an admin
-	user, _ := c.Get("user")
-	u := user.(models.User)
-	if problem.CreatedBy != u.ID && u.Role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to update this problem"})
-		return
-	}
+	// Access is checked by the route's RequireOn
+	u := c.MustGet("user").(*models.User)
 
 	var updateData CreateProblemRequest
 	if err := c.ShouldBindJSON(&updateData); err != nil {
@@ -138,60 +556,122 @@ func UpdateProblem(c *gin.Context) {
 		return
 	}
 
+	if !services.SupportedCheckers[updateData.Checker] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported checker"})
+		return
+	}
+
+	if err := services.ValidateIOMode(updateData.IOMode, updateData.InputFile, updateData.OutputFile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ValidatePolicy(updateData.Policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	statements, ok := updateData.statements(c)
+	if !ok {
+		return
+	}
+
+	harnesses, ok := updateData.harnesses(c)
+	if !ok {
+		return
+	}
+
+	tests, generate, ok := checkTestSet(c, h.evaluator, &updateData)
+	if !ok {
+		return
+	}
+
+	// Snapshot the problem for the audit log before it changes
+	previous := problem
+	db.Model(&problem).Association("Tags").Find(&previous.Tags)
+	previousRevision, _ := services.CurrentRevision(db, &problem)
+	before := services.AuditProblem(&previous, previousRevision)
+
 	// Update problem fields
 	problem.Title = updateData.Title
 	problem.Description = updateData.Description
 	problem.Difficulty = updateData.Difficulty
+	problem.Points = updateData.Points
 	problem.TimeLimit = updateData.TimeLimit
 	problem.MemoryLimit = updateData.MemoryLimit
-
-	// Update test cases
-	database.DB.Where("problem_id = ?", problem.ID).Delete(&models.TestCase{})
-	for _, tc := range updateData.TestCases {
-		problem.TestCases = append(problem.TestCases, models.TestCase{
-			Input:    tc.Input,
-			Output:   tc.Output,
-			IsSample: tc.IsSample,
-		})
-	}
+	problem.Checker = updateData.Checker
+	problem.IOMode = updateData.IOMode
+	problem.InputFile = updateData.InputFile
+	problem.OutputFile = updateData.OutputFile
+	problem.TestScript = updateData.TestScript
+	problem.Signature = updateData.Signature
+	problem.Policy = updateData.Policy
 
 	// Update tags
-	database.DB.Model(&problem).Association("Tags").Clear()
+	db.Model(&problem).Association("Tags").Clear()
 	for _, tagName := range updateData.Tags {
 		var tag models.Tag
-		database.DB.FirstOrCreate(&tag, models.Tag{Name: tagName})
+		db.FirstOrCreate(&tag, models.Tag{Name: tagName})
 		problem.Tags = append(problem.Tags, tag)
 	}
 
-	if err := database.DB.Save(&problem).Error; err != nil {
+	// Save the problem and snapshot it as a new revision. Tests of earlier
+	// revisions are left untouched for the submissions judged against them.
+	var revision *models.ProblemRevision
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&problem).Error; err != nil {
+			return err
+		}
+		if err := saveProblemPrograms(tx, problem.ID, updateData.programs()); err != nil {
+			return err
+		}
+		if err := saveProblemHarnesses(tx, problem.ID, harnesses); err != nil {
+			return err
+		}
+		var err error
+		revision, err = services.CreateRevision(tx, &problem, services.RevisionContent{
+			Tests:      tests,
+			Statements: statements,
+			Harnesses:  harnesses,
+			Generate:   generate,
+		}, u.ID, updateData.Message)
+		if err != nil {
+			return err
+		}
+		problem.TestCases = revision.TestCases
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update problem"})
 		return
 	}
 
+	audit(c, db, services.AuditProblemUpdate, services.ResourceProblem, problem.ID, before, services.AuditProblem(&problem, revision))
+
+	// Re-check the reference solutions against the new tests and limits
+	if !queueRevision(c, db, h.broker, revision) {
+		return
+	}
+
 	c.JSON(http.StatusOK, problem)
 }
 
-func DeleteProblem(c *gin.Context) {
+func (h *ProblemHandler) DeleteProblem(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	id := c.Param("id")
 	var problem models.Problem
 
-	if err := database.DB.First(&problem, id).Error; err != nil {
+	if err := db.Preload("Tags").First(&problem, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Problem not found"})
 		return
 	}
+	revision, _ := services.CurrentRevision(db, &problem)
 
-	// Check if user is the creator or an admin
-	user, _ := c.Get("user")
-	u := user.(models.User)
-	if problem.CreatedBy != u.ID && u.Role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to delete this problem"})
-		return
-	}
-
-	if err := database.DB.Delete(&problem).Error; err != nil {
+	if err := db.Delete(&problem).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete problem"})
 		return
 	}
+	audit(c, db, services.AuditProblemDelete, services.ResourceProblem, problem.ID, services.AuditProblem(&problem, revision), nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Problem deleted successfully"})
-} 
\ No newline at end of file
+}
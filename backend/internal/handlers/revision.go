@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
+	"github.com/onlinejudge/backend/pkg/broker"
+	"gorm.io/gorm"
+)
+
+type RevisionHandler struct {
+	db     *gorm.DB
+	broker *broker.NATSClient
+}
+
+func NewRevisionHandler(db *gorm.DB, broker *broker.NATSClient) *RevisionHandler {
+	return &RevisionHandler{
+		db:     db,
+		broker: broker,
+	}
+}
+
+type RejudgeRequest struct {
+	SubmissionIDs []uint `json:"submission_ids"` // empty means every submission to the problem
+}
+
+// loadProblem fetches the problem from the route. Access is checked by the
+// route's RequireOn. It writes the error response itself.
+func (h *RevisionHandler) loadProblem(c *gin.Context) (*models.Problem, bool) {
+	db := h.db.WithContext(c.Request.Context())
+	var problem models.Problem
+	if err := db.First(&problem, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "problem not found"})
+		return nil, false
+	}
+
+	return &problem, true
+}
+
+// loadRevision resolves the :number route parameter against problem.
+func (h *RevisionHandler) loadRevision(c *gin.Context, problem *models.Problem) (*models.ProblemRevision, bool) {
+	db := h.db.WithContext(c.Request.Context())
+	number, err := strconv.Atoi(c.Param("number"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid revision number"})
+		return nil, false
+	}
+
+	revision, err := services.GetRevision(db, problem.ID, number)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "revision not found"})
+		return nil, false
+	}
+
+	return revision, true
+}
+
+// testsReady reports whether code can be judged against the revision's
+// tests, writing a conflict response when they are still generating or
+// failed to generate.
+func testsReady(c *gin.Context, revision *models.ProblemRevision) bool {
+	switch revision.TestStatus {
+	case models.TestsGenerating:
+		c.JSON(http.StatusConflict, gin.H{"error": "problem tests are still being generated"})
+		return false
+	case models.TestsFailed:
+		c.JSON(http.StatusConflict, gin.H{"error": "problem tests failed to generate: " + revision.TestError})
+		return false
+	}
+	return true
+}
+
+// queueRevision hands a saved revision to the judge when its tests are still
+// generating or its reference solutions need verifying. It writes the error
+// response itself.
+func queueRevision(c *gin.Context, db *gorm.DB, broker *broker.NATSClient, revision *models.ProblemRevision) bool {
+	verify, err := services.RequestVerification(db, revision)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !verify && revision.TestStatus != models.TestsGenerating {
+		return true
+	}
+	if err := broker.PublishRevision(c.Request.Context(), revision); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to queue revision"})
+		return false
+	}
+	return true
+}
+
+func (h *RevisionHandler) ListRevisions(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	problem, ok := h.loadProblem(c)
+	if !ok {
+		return
+	}
+
+	var revisions []models.ProblemRevision
+	if err := db.Where("problem_id = ?", problem.ID).Order("number DESC").Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"current":   problem.RevisionID,
+		"revisions": revisions,
+	})
+}
+
+func (h *RevisionHandler) GetRevision(c *gin.Context) {
+	problem, ok := h.loadProblem(c)
+	if !ok {
+		return
+	}
+
+	revision, ok := h.loadRevision(c, problem)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, revision)
+}
+
+// DiffRevision compares the revision in the route with the one given by the
+// "from" query parameter, defaulting to the preceding revision.
+func (h *RevisionHandler) DiffRevision(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	problem, ok := h.loadProblem(c)
+	if !ok {
+		return
+	}
+
+	to, ok := h.loadRevision(c, problem)
+	if !ok {
+		return
+	}
+
+	fromNumber := to.Number - 1
+	if from := c.Query("from"); from != "" {
+		n, err := strconv.Atoi(from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from revision"})
+			return
+		}
+		fromNumber = n
+	}
+
+	from, err := services.GetRevision(db, problem.ID, fromNumber)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "from revision not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, services.DiffRevisions(from, to))
+}
+
+// Rollback restores the problem to the contents of an earlier revision. The
+// restored state is recorded as a new revision so history stays append-only.
+func (h *RevisionHandler) Rollback(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	problem, ok := h.loadProblem(c)
+	if !ok {
+		return
+	}
+
+	target, ok := h.loadRevision(c, problem)
+	if !ok {
+		return
+	}
+
+	if !testsReady(c, target) {
+		return
+	}
+
+	u := c.MustGet("user").(*models.User)
+
+	current, err := services.CurrentRevision(db, problem)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	before := services.AuditProblem(problem, current)
+
+	problem.Title = target.Title
+	problem.Description = target.Description
+	problem.Difficulty = target.Difficulty
+	problem.TimeLimit = target.TimeLimit
+	problem.MemoryLimit = target.MemoryLimit
+	problem.Checker = target.Checker
+	problem.IOMode = target.IOMode
+	problem.InputFile = target.InputFile
+	problem.OutputFile = target.OutputFile
+	problem.Points = target.Points
+	problem.TestScript = target.TestScript
+	problem.Signature = target.Signature
+	problem.Policy = target.Policy
+
+	harnesses := make([]models.ProblemHarness, 0, len(target.Harnesses))
+	for _, h := range target.Harnesses {
+		harnesses = append(harnesses, models.ProblemHarness{
+			Language: h.Language,
+			Template: h.Template,
+			Harness:  h.Harness,
+		})
+	}
+
+	var revision *models.ProblemRevision
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(problem).Error; err != nil {
+			return err
+		}
+		if err := saveProblemHarnesses(tx, problem.ID, harnesses); err != nil {
+			return err
+		}
+		var err error
+		revision, err = services.CreateRevision(tx, problem, services.RevisionContent{
+			Tests:      target.TestCases,
+			Statements: target.Statements,
+			Harnesses:  harnesses,
+		}, u.ID, fmt.Sprintf("Rollback to revision %d", target.Number))
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit(c, db, services.AuditProblemRollback, services.ResourceProblem, problem.ID, before, services.AuditProblem(problem, revision))
+	if !queueRevision(c, db, h.broker, revision) {
+		return
+	}
+
+	c.JSON(http.StatusOK, revision)
+}
+
+// GetVerification reports how the problem's reference solutions fared on a
+// revision, listing those whose verdict differs from their tag separately.
+func (h *RevisionHandler) GetVerification(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	problem, ok := h.loadProblem(c)
+	if !ok {
+		return
+	}
+
+	revision, ok := h.loadRevision(c, problem)
+	if !ok {
+		return
+	}
+
+	var checks []models.SolutionCheck
+	if err := db.Where("revision_id = ?", revision.ID).Order("id").Find(&checks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	mismatches := []models.SolutionCheck{}
+	for _, check := range checks {
+		if !check.Matches {
+			mismatches = append(mismatches, check)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revision":             revision.Number,
+		"status":               revision.VerificationStatus,
+		"time_limit":           revision.TimeLimit,
+		"suggested_time_limit": revision.SuggestedTimeLimit,
+		"checks":               checks,
+		"mismatches":           mismatches,
+	})
+}
+
+// Verify re-runs the reference solutions against a revision on demand.
+func (h *RevisionHandler) Verify(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	problem, ok := h.loadProblem(c)
+	if !ok {
+		return
+	}
+
+	revision, ok := h.loadRevision(c, problem)
+	if !ok {
+		return
+	}
+
+	if !testsReady(c, revision) {
+		return
+	}
+
+	if !queueRevision(c, db, h.broker, revision) {
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"revision": revision.Number,
+		"status":   revision.VerificationStatus,
+	})
+}
+
+// Rejudge re-queues submissions to the problem against the revision in the
+// route, discarding their previous per-test results.
+func (h *RevisionHandler) Rejudge(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	problem, ok := h.loadProblem(c)
+	if !ok {
+		return
+	}
+
+	revision, ok := h.loadRevision(c, problem)
+	if !ok {
+		return
+	}
+
+	if !testsReady(c, revision) {
+		return
+	}
+
+	var req RejudgeRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	query := db.Where("problem_id = ?", problem.ID)
+	if len(req.SubmissionIDs) > 0 {
+		query = query.Where("id IN ?", req.SubmissionIDs)
+	}
+
+	var submissions []models.Submission
+	if err := query.Find(&submissions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ids := make([]uint, 0, len(submissions))
+	for _, s := range submissions {
+		ids = append(ids, s.ID)
+	}
+
+	// Take each old verdict out of the problem statistics before resetting
+	// it, one submission at a time so solver counts stay consistent
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i := range submissions {
+			if err := services.ForgetVerdict(tx, &submissions[i]); err != nil {
+				return err
+			}
+			if err := tx.Model(&submissions[i]).Updates(map[string]interface{}{
+				"status":      "pending",
+				"error":       "",
+				"revision_id": revision.ID,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("submission_id IN ?", ids).Delete(&models.SubmissionResult{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	queued := 0
+	for i := range submissions {
+		submissions[i].Status = "pending"
+		submissions[i].Error = ""
+		submissions[i].RevisionID = revision.ID
+		if err := h.broker.PublishSubmission(c.Request.Context(), &submissions[i]); err != nil {
+			continue
+		}
+		queued++
+	}
+
+	auditChanges(c, db, services.AuditProblemRejudge, services.ResourceProblem, problem.ID, []models.AuditChange{
+		{Field: "revision", To: revision.Number},
+		{Field: "submissions", To: len(submissions)},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"revision": revision.Number,
+		"total":    len(submissions),
+		"queued":   queued,
+	})
+}
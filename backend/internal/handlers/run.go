@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/onlinejudge/backend/internal/services"
+)
+
+const (
+	maxRunCodeSize  = 64 << 10
+	maxRunInputSize = 1 << 20
+)
+
+type RunRequest struct {
+	Language string `json:"language" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+	Stdin    string `json:"stdin"`
+}
+
+// RunHandler serves custom invocations. The route is rate limited per
+// user by the "run" policy.
+type RunHandler struct {
+	evaluator *services.Evaluator
+}
+
+func NewRunHandler(evaluator *services.Evaluator) *RunHandler {
+	return &RunHandler{evaluator: evaluator}
+}
+
+// Run executes code on the given stdin without creating a submission, so
+// users can try their solution on their own input.
+func (h *RunHandler) Run(c *gin.Context) {
+	var req RunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !services.SupportedLanguages[req.Language] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported language"})
+		return
+	}
+	if len(req.Code) > maxRunCodeSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "code exceeds 64 KB"})
+		return
+	}
+	if len(req.Stdin) > maxRunInputSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "input exceeds 1 MB"})
+		return
+	}
+
+	result, err := h.evaluator.RunCustom(c.Request.Context(), req.Language, req.Code, req.Stdin)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
+	"gorm.io/gorm"
+)
+
+type SessionHandler struct {
+	db *gorm.DB
+}
+
+func NewSessionHandler(db *gorm.DB) *SessionHandler {
+	return &SessionHandler{db: db}
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// Refresh exchanges a refresh token for a new access token and a new
+// refresh token. The old refresh token stops working.
+func (h *SessionHandler) Refresh(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := services.RefreshSession(db, req.RefreshToken)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidRefreshToken, services.ErrSessionRevoked:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh session"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the session of the access token used for the request.
+func (h *SessionHandler) Logout(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	u := c.MustGet("user").(*models.User)
+	if err := services.RevokeSession(db, u.ID, c.GetUint("session_id")); err != nil && err != gorm.ErrRecordNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
+
+// LogoutAll revokes every session of the current user, this one included.
+func (h *SessionHandler) LogoutAll(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	u := c.MustGet("user").(*models.User)
+	if err := services.RevokeUserSessions(db, u.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "logged out of all sessions"})
+}
+
+// ListSessions lists the current user's active sessions, marking the one
+// the request was made with.
+func (h *SessionHandler) ListSessions(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	u := c.MustGet("user").(*models.User)
+
+	var sessions []models.Session
+	if err := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > NOW()", u.ID).
+		Order("last_used_at DESC").Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"current":  c.GetUint("session_id"),
+		"sessions": sessions,
+	})
+}
+
+// RevokeSession logs the current user out of one of their sessions.
+func (h *SessionHandler) RevokeSession(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session id"})
+		return
+	}
+
+	u := c.MustGet("user").(*models.User)
+	if err := services.RevokeSession(db, u.ID, uint(id)); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
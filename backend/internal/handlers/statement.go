@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
+	"github.com/onlinejudge/backend/pkg/storage"
+	"gorm.io/gorm"
+)
+
+// maxAttachmentSize limits a single statement attachment to 10 MB.
+const maxAttachmentSize = 10 << 20
+
+// attachmentName restricts names to what statements can link to verbatim.
+var attachmentName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// inlineAttachmentTypes are the content types served for display in the
+// browser. Anything else, HTML and SVG included, is only offered as a
+// download so that attachments cannot run script on the API's origin.
+var inlineAttachmentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// sniffContentType detects the type of an upload from its content rather
+// than trusting the uploader, keeping only inline types.
+func sniffContentType(src io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	contentType := http.DetectContentType(head[:n])
+	if !inlineAttachmentTypes[contentType] {
+		return "application/octet-stream", nil
+	}
+	return contentType, nil
+}
+
+type StatementHandler struct {
+	db    *gorm.DB
+	store storage.Store
+}
+
+func NewStatementHandler(db *gorm.DB, store storage.Store) *StatementHandler {
+	return &StatementHandler{
+		db:    db,
+		store: store,
+	}
+}
+
+// GetStatement returns one translation of the current statement, chosen by
+// the "lang" query parameter or the Accept-Language header.
+func (h *StatementHandler) GetStatement(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var problem models.Problem
+	if err := db.First(&problem, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "problem not found"})
+		return
+	}
+
+	revision, err := services.CurrentRevision(db, &problem)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	statement := selectStatement(c, revision.Statements)
+	if statement == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "problem has no statement"})
+		return
+	}
+
+	languages := make([]string, 0, len(revision.Statements))
+	for _, st := range revision.Statements {
+		languages = append(languages, st.Language)
+	}
+
+	c.Header("Content-Language", statement.Language)
+	c.Header("Vary", "Accept-Language")
+	c.JSON(http.StatusOK, gin.H{
+		"statement": statement,
+		"languages": languages,
+	})
+}
+
+func (h *StatementHandler) ListAttachments(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var attachments []models.ProblemAttachment
+	if err := db.Where("problem_id = ?", c.Param("id")).Order("name").Find(&attachments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, attachments)
+}
+
+// GetAttachment streams an attachment so statements can reference it as
+// /api/problems/:id/attachments/:name.
+func (h *StatementHandler) GetAttachment(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var attachment models.ProblemAttachment
+	if err := db.Where("problem_id = ? AND name = ?", c.Param("id"), c.Param("name")).First(&attachment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+
+	body, err := h.store.Get(c.Request.Context(), attachment.Key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer body.Close()
+
+	// Attachments stored before uploads were sniffed may have any type
+	contentType := attachment.ContentType
+	c.Header("X-Content-Type-Options", "nosniff")
+	if !inlineAttachmentTypes[contentType] {
+		contentType = "application/octet-stream"
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", attachment.Name))
+	}
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.DataFromReader(http.StatusOK, attachment.Size, contentType, body, nil)
+}
+
+// UploadAttachment stores the multipart "file" field under its file name,
+// replacing an existing attachment with the same name.
+func (h *StatementHandler) UploadAttachment(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	problem, ok := h.loadProblem(c)
+	if !ok {
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	if file.Size > maxAttachmentSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "attachment exceeds 10 MB"})
+		return
+	}
+
+	name := c.DefaultPostForm("name", file.Filename)
+	if !attachmentName.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid attachment name"})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer src.Close()
+
+	contentType, err := sniffContentType(src)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key := fmt.Sprintf("problems/%d/attachments/%s", problem.ID, name)
+	if err := h.store.Put(c.Request.Context(), key, src, file.Size, contentType); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store attachment"})
+		return
+	}
+
+	u := c.MustGet("user").(*models.User)
+	attachment := models.ProblemAttachment{ProblemID: problem.ID, Name: name}
+	var before interface{}
+	if db.Where(&attachment).First(&attachment).Error == nil {
+		before = attachmentAudit(&attachment)
+	}
+	attachment.ContentType = contentType
+	attachment.Size = file.Size
+	attachment.Key = key
+	attachment.CreatedBy = u.ID
+
+	if err := db.Save(&attachment).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit(c, db, services.AuditAttachmentUpload, services.ResourceProblem, problem.ID, before, attachmentAudit(&attachment))
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+func (h *StatementHandler) DeleteAttachment(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	problem, ok := h.loadProblem(c)
+	if !ok {
+		return
+	}
+
+	var attachment models.ProblemAttachment
+	if err := db.Where("problem_id = ? AND name = ?", problem.ID, c.Param("name")).First(&attachment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+
+	if err := h.store.Delete(c.Request.Context(), attachment.Key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete attachment"})
+		return
+	}
+	if err := db.Delete(&attachment).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit(c, db, services.AuditAttachmentDelete, services.ResourceProblem, problem.ID, attachmentAudit(&attachment), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "attachment deleted successfully"})
+}
+
+// attachmentAudit is what the audit log records of an attachment, keyed by
+// name so that a replaced file still names the attachment.
+func attachmentAudit(attachment *models.ProblemAttachment) gin.H {
+	return gin.H{"attachments/" + attachment.Name: gin.H{
+		"content_type": attachment.ContentType,
+		"size":         attachment.Size,
+	}}
+}
+
+func (h *StatementHandler) loadProblem(c *gin.Context) (*models.Problem, bool) {
+	db := h.db.WithContext(c.Request.Context())
+	var problem models.Problem
+	if err := db.First(&problem, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "problem not found"})
+		return nil, false
+	}
+
+	return &problem, true
+}
+
+// selectStatement picks the translation for the request: the "lang" query
+// parameter first, then Accept-Language by preference, then English, then
+// whichever translation was added first.
+func selectStatement(c *gin.Context, statements []models.ProblemStatement) *models.ProblemStatement {
+	if len(statements) == 0 {
+		return nil
+	}
+
+	wanted := preferredLanguages(c.GetHeader("Accept-Language"))
+	if lang := c.Query("lang"); lang != "" {
+		wanted = append([]string{lang}, wanted...)
+	}
+	wanted = append(wanted, "en")
+
+	for _, lang := range wanted {
+		lang = strings.ToLower(lang)
+		for i := range statements {
+			if strings.ToLower(statements[i].Language) == lang {
+				return &statements[i]
+			}
+		}
+		// Fall back from a regional tag such as "fa-IR" to "fa"
+		if base, _, found := strings.Cut(lang, "-"); found {
+			for i := range statements {
+				if strings.ToLower(statements[i].Language) == base {
+					return &statements[i]
+				}
+			}
+		}
+	}
+
+	return &statements[0]
+}
+
+// preferredLanguages parses an Accept-Language header into language tags
+// ordered by descending quality.
+func preferredLanguages(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			langs = append(langs, weighted{tag: tag, q: q})
+		}
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+
+	tags := make([]string, 0, len(langs))
+	for _, l := range langs {
+		tags = append(tags, l.tag)
+	}
+	return tags
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
+	"gorm.io/gorm"
+)
+
+type StatsHandler struct {
+	db *gorm.DB
+}
+
+func NewStatsHandler(db *gorm.DB) *StatsHandler {
+	return &StatsHandler{db: db}
+}
+
+// GetProblemStats returns submission counts, the verdict distribution and
+// per-language runtime and memory histograms of accepted runs.
+func (h *StatsHandler) GetProblemStats(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var problem models.Problem
+	if err := db.First(&problem, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "problem not found"})
+		return
+	}
+
+	report, err := services.GetProblemStats(db, problem.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// RebuildProblemStats recomputes a problem's statistics from scratch.
+func (h *StatsHandler) RebuildProblemStats(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var problem models.Problem
+	if err := db.First(&problem, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "problem not found"})
+		return
+	}
+
+	if err := services.RebuildProblemStats(db, problem.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := services.GetProblemStats(db, problem.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetUserProfile returns the public profile of the user in the route:
+// solved and attempted problems, activity heatmap, streaks and contests.
+func (h *StatsHandler) GetUserProfile(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var user models.User
+	if err := db.Where("username = ?", c.Param("username")).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	profile, err := services.GetUserProfile(db, &user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
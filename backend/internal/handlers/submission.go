@@ -1,28 +1,42 @@
 package handlers
 
 import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"math"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
 	"github.com/onlinejudge/backend/pkg/broker"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// maxOutputArchiveSize limits answer archives of output-only problems. The
+// archive travels base64-encoded inside the NATS message, whose payload is
+// capped at 1 MB by default.
+const maxOutputArchiveSize = 512 << 10
+
 type SubmissionHandler struct {
-	db     *gorm.DB
-	broker *broker.NATSClient
+	db        *gorm.DB
+	broker    *broker.NATSClient
+	evaluator *services.Evaluator // runs sample submissions in the request
 }
 
-func NewSubmissionHandler(db *gorm.DB, broker *broker.NATSClient) *SubmissionHandler {
+func NewSubmissionHandler(db *gorm.DB, broker *broker.NATSClient, evaluator *services.Evaluator) *SubmissionHandler {
 	return &SubmissionHandler{
-		db:     db,
-		broker: broker,
+		db:        db,
+		broker:    broker,
+		evaluator: evaluator,
 	}
 }
 
 func (h *SubmissionHandler) Submit(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	var submission models.Submission
 	if err := c.ShouldBindJSON(&submission); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -39,22 +53,234 @@ func (h *SubmissionHandler) Submit(c *gin.Context) {
 
 	// Verify problem exists
 	var problem models.Problem
-	if err := h.db.First(&problem, submission.ProblemID).Error; err != nil {
+	if err := db.First(&problem, submission.ProblemID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "problem not found"})
+		return
+	}
+
+	// Multi-file submissions come as a map of files or a zip archive
+	if submission.Archive != "" {
+		archive, err := base64.StdEncoding.DecodeString(submission.Archive)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "archive must be base64 encoded"})
+			return
+		}
+		submission.Files, err = services.ReadSourceArchive(archive)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		submission.Archive = ""
+	}
+	if len(submission.Files) > 0 {
+		if problem.Signature != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "function-style problems take a single file"})
+			return
+		}
+		if err := services.ValidateSourceFiles(submission.Language, submission.Files); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		submission.Code = ""
+	}
+
+	// Output-only problems take answer archives through SubmitOutputs
+	if problem.IOMode == models.IOOutputOnly || submission.Language == models.LanguageOutputOnly {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "output-only problems accept answer archives at /api/submissions/outputs"})
+		return
+	}
+
+	// Pin the revision the submission will be judged against
+	revision, err := services.CurrentRevision(db, &problem)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load problem revision"})
+		return
+	}
+	if !testsReady(c, revision) {
+		return
+	}
+	submission.RevisionID = revision.ID
+
+	// Function-style problems only accept languages the revision has a harness for
+	if revision.Signature != nil {
+		var count int64
+		db.Model(&models.RevisionHarness{}).
+			Where("revision_id = ? AND language = ?", revision.ID, submission.Language).
+			Count(&count)
+		if count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrNoHarness.Error()})
+			return
+		}
+	}
+
+	if c.Query("mode") == "samples" {
+		if err := checkPolicy(db, &problem, &submission, false); err != nil {
+			policyError(c, err)
+			return
+		}
+		h.submitSamples(c, &submission, revision)
+		return
+	}
+
+	h.queue(c, &problem, &submission)
+}
+
+// SubmitOutputs accepts a zip of precomputed answers to an output-only
+// problem as the multipart "file" field, with the answer to test n stored
+// as "<n>.out".
+func (h *SubmissionHandler) SubmitOutputs(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	problemID, err := strconv.ParseUint(c.PostForm("problem_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "problem_id is required"})
+		return
+	}
+
+	var problem models.Problem
+	if err := db.First(&problem, problemID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "problem not found"})
 		return
 	}
+	if problem.IOMode != models.IOOutputOnly {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "problem does not accept output-only submissions"})
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	if file.Size > maxOutputArchiveSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "archive exceeds 512 KB"})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer src.Close()
+
+	archive, err := io.ReadAll(io.LimitReader(src, maxOutputArchiveSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := services.ReadOutputArchive(archive); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	revision, err := services.CurrentRevision(db, &problem)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load problem revision"})
+		return
+	}
+	if !testsReady(c, revision) {
+		return
+	}
+
+	submission := models.Submission{
+		UserID:     c.MustGet("user").(*models.User).ID,
+		ProblemID:  problem.ID,
+		RevisionID: revision.ID,
+		Language:   models.LanguageOutputOnly,
+		Code:       base64.StdEncoding.EncodeToString(archive),
+	}
+	if v := c.PostForm("contest_id"); v != "" {
+		contestID, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid contest_id"})
+			return
+		}
+		id := uint(contestID)
+		submission.ContestID = &id
+	}
+
+	h.queue(c, &problem, &submission)
+}
+
+// errWrongContest is returned by checkPolicy for a contest_id other than
+// the contest the submission belongs to.
+var errWrongContest = errors.New("contest_id is not a running contest you are registered in that includes this problem")
+
+// checkPolicy applies the problem's and contest's submission policies,
+// returning a *services.PolicyViolation if the submission breaks one.
+// Submissions are attributed to the running contest the user is registered
+// in that includes the problem, if any; a contest_id given by the client
+// must name that contest. Sample runs are not counted against submission
+// limits.
+func checkPolicy(db *gorm.DB, problem *models.Problem, submission *models.Submission, counted bool) error {
+	contest, err := services.ResolveContest(db, submission.UserID, problem.ID)
+	if err != nil {
+		return err
+	}
+	if submission.ContestID != nil && (contest == nil || *submission.ContestID != contest.ID) {
+		return errWrongContest
+	}
+	if contest != nil {
+		submission.ContestID = &contest.ID
+	}
+
+	return services.CheckSubmissionPolicy(db, problem, contest, submission, counted)
+}
+
+// policyError writes the response for an error from checkPolicy.
+func policyError(c *gin.Context, err error) {
+	if err == errWrongContest {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	violation, ok := err.(*services.PolicyViolation)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check submission policy"})
+		return
+	}
+
+	status := http.StatusBadRequest
+	switch violation.Code {
+	case services.PolicySourceSize:
+		status = http.StatusRequestEntityTooLarge
+	case services.PolicyCooldown:
+		status = http.StatusTooManyRequests
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(violation.RetryAfter.Seconds()))))
+	case services.PolicySubmissions:
+		status = http.StatusForbidden
+	}
+	c.JSON(status, gin.H{"error": violation.Message, "code": violation.Code})
+}
+
+// queue checks the submission against the policies and stores it as
+// pending, then publishes it for judging. The check and the insert share a
+// transaction that locks the user's row, so concurrent submissions from one
+// user are checked one after the other.
+func (h *SubmissionHandler) queue(c *gin.Context, problem *models.Problem, submission *models.Submission) {
+	db := h.db.WithContext(c.Request.Context())
 
 	// Set initial status
 	submission.Status = "pending"
 
 	// Save submission
-	if err := h.db.Create(&submission).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Take(&models.User{}, submission.UserID).Error; err != nil {
+			return err
+		}
+		if err := checkPolicy(tx, problem, submission, true); err != nil {
+			return err
+		}
+		return tx.Create(submission).Error
+	})
+	if err != nil {
+		policyError(c, err)
 		return
 	}
 
 	// Publish to NATS for evaluation
-	if err := h.broker.PublishSubmission(&submission); err != nil {
+	if err := h.broker.PublishSubmission(c.Request.Context(), submission); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to queue submission"})
 		return
 	}
@@ -62,11 +288,95 @@ func (h *SubmissionHandler) Submit(c *gin.Context) {
 	c.JSON(http.StatusCreated, submission)
 }
 
+// submitSamples judges the submission on the problem's sample tests right
+// away and stores the outcome as a SampleRun instead of a Submission.
+func (h *SubmissionHandler) submitSamples(c *gin.Context, submission *models.Submission, revision *models.ProblemRevision) {
+	db := h.db.WithContext(c.Request.Context())
+	run := models.SampleRun{
+		UserID:     submission.UserID,
+		ProblemID:  submission.ProblemID,
+		ContestID:  submission.ContestID,
+		RevisionID: revision.ID,
+		Language:   submission.Language,
+		Code:       submission.Code,
+		Files:      submission.Files,
+	}
+
+	if err := h.evaluator.RunSamples(c.Request.Context(), &run, revision); err != nil {
+		if err == services.ErrNoSamples {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.Create(&run).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, run)
+}
+
+// GetSampleRun returns one of the current user's sample runs with its
+// per-sample outputs.
+func (h *SubmissionHandler) GetSampleRun(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	u := c.MustGet("user").(*models.User)
+
+	var run models.SampleRun
+	if err := db.Preload("Results").Where("user_id = ?", u.ID).First(&run, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sample run not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// ListSampleRuns lists the current user's sample runs, newest first,
+// optionally for a single problem.
+func (h *SubmissionHandler) ListSampleRuns(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	u := c.MustGet("user").(*models.User)
+	query := db.Model(&models.SampleRun{}).Where("user_id = ?", u.ID)
+
+	if problemID := c.Query("problem_id"); problemID != "" {
+		query = query.Where("problem_id = ?", problemID)
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	offset := (page - 1) * pageSize
+
+	var total int64
+	query.Count(&total)
+
+	var runs []models.SampleRun
+	if err := query.Omit("Code", "Files").
+		Offset(offset).Limit(pageSize).
+		Order("created_at DESC").
+		Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": runs,
+		"meta": gin.H{
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
 func (h *SubmissionHandler) GetSubmission(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	id := c.Param("id")
 	var submission models.Submission
 
-	if err := h.db.Preload("User").Preload("Problem").First(&submission, id).Error; err != nil {
+	if err := db.Preload("User").Preload("Problem").First(&submission, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "submission not found"})
 		return
 	}
@@ -75,8 +385,9 @@ func (h *SubmissionHandler) GetSubmission(c *gin.Context) {
 }
 
 func (h *SubmissionHandler) ListSubmissions(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	var submissions []models.Submission
-	query := h.db.Model(&models.Submission{})
+	query := db.Model(&models.Submission{})
 
 	// Apply filters
 	if problemID := c.Query("problem_id"); problemID != "" {
@@ -118,13 +429,14 @@ func (h *SubmissionHandler) ListSubmissions(c *gin.Context) {
 }
 
 func (h *SubmissionHandler) GetSubmissionResults(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	id := c.Param("id")
 	var submission models.Submission
 
-	if err := h.db.Preload("Results").First(&submission, id).Error; err != nil {
+	if err := db.Preload("Results").First(&submission, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "submission not found"})
 		return
 	}
 
 	c.JSON(http.StatusOK, submission.Results)
-} 
\ No newline at end of file
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
+	"gorm.io/gorm"
+)
+
+type TwoFactorHandler struct {
+	db *gorm.DB
+}
+
+func NewTwoFactorHandler(db *gorm.DB) *TwoFactorHandler {
+	return &TwoFactorHandler{db: db}
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"` // TOTP code, or a recovery code where noted
+}
+
+type LoginChallengeRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+}
+
+// twoFactorError maps service errors to responses.
+func twoFactorError(c *gin.Context, err error) {
+	switch err {
+	case services.ErrInvalidTwoFactorCode, services.ErrInvalidChallenge:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case services.ErrTwoFactorEnabled, services.ErrTwoFactorNotEnabled:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case services.ErrTwoFactorRequired:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// Verify completes a login that returned a two-factor challenge, with a
+// TOTP code or a recovery code.
+func (h *TwoFactorHandler) Verify(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var req LoginChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := services.CompleteLoginChallenge(db, req.Challenge, req.Code)
+	if err != nil {
+		twoFactorError(c, err)
+		return
+	}
+
+	tokens, err := services.StartSession(db, user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, loginResponse(tokens, user))
+}
+
+// Status tells the current user whether 2FA is on and required.
+func (h *TwoFactorHandler) Status(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	u := c.MustGet("user").(*models.User)
+
+	enabled := services.TwoFactorEnabled(db, u.ID)
+	resp := gin.H{
+		"enabled":  enabled,
+		"required": services.TwoFactorRequired(u.Role),
+	}
+	if enabled {
+		resp["recovery_codes_left"] = services.RecoveryCodesLeft(db, u.ID)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Enroll creates a TOTP secret for the current user. 2FA is not on until
+// Confirm receives a code from it.
+func (h *TwoFactorHandler) Enroll(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	u := c.MustGet("user").(*models.User)
+
+	enrollment, err := services.BeginTwoFactor(db, u)
+	if err != nil {
+		twoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// Confirm turns 2FA on with a TOTP code and returns the recovery codes,
+// which are shown only this once.
+func (h *TwoFactorHandler) Confirm(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u := c.MustGet("user").(*models.User)
+	codes, err := services.ConfirmTwoFactor(db, u, req.Code)
+	if err != nil {
+		twoFactorError(c, err)
+		return
+	}
+	auditChanges(c, db, services.AuditTwoFactorEnable, services.AuditTargetUser, u.ID,
+		[]models.AuditChange{{Field: "two_factor", From: false, To: true}})
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes, given a TOTP code or
+// one of the old recovery codes.
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u := c.MustGet("user").(*models.User)
+	codes, err := services.RegenerateRecoveryCodes(db, u, req.Code)
+	if err != nil {
+		twoFactorError(c, err)
+		return
+	}
+	auditChanges(c, db, services.AuditRecoveryCodesRegenerate, services.AuditTargetUser, u.ID,
+		[]models.AuditChange{{Field: "recovery_codes"}})
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// Disable turns 2FA off, given a TOTP code or a recovery code.
+func (h *TwoFactorHandler) Disable(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u := c.MustGet("user").(*models.User)
+	if err := services.DisableTwoFactor(db, u, req.Code); err != nil {
+		twoFactorError(c, err)
+		return
+	}
+	auditChanges(c, db, services.AuditTwoFactorDisable, services.AuditTargetUser, u.ID,
+		[]models.AuditChange{{Field: "two_factor", From: true, To: false}})
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
@@ -5,59 +5,26 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	"github.com/onlinejudge/backend/internal/models"
-	"github.com/onlinejudge/backend/pkg/database"
+	"github.com/onlinejudge/backend/internal/services"
+	"gorm.io/gorm"
 )
 
-type RegisterRequest struct {
-	Username string `json:"username" binding:"required"`
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
-}
-
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
 }
 
-func Register(c *gin.Context) {
-	var req RegisterRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Check if user already exists
-	var existingUser models.User
-	if err := database.DB.Where("email = ? OR username = ?", req.Email, req.Username).First(&existingUser).Error; err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
-		return
-	}
-
-	// Create new user
-	user := models.User{
-		Username: req.Username,
-		Email:    req.Email,
-		Password: req.Password,
-	}
-
-	// Hash password
-	if err := user.HashPassword(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
-		return
-	}
-
-	// Save user
-	if err := database.DB.Create(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
-		return
-	}
+type UserHandler struct {
+	db *gorm.DB
+}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully"})
+func NewUserHandler(db *gorm.DB) *UserHandler {
+	return &UserHandler{db: db}
 }
 
-func Login(c *gin.Context) {
+func (h *UserHandler) Login(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -66,7 +33,7 @@ func Login(c *gin.Context) {
 
 	// Find user
 	var user models.User
-	if err := database.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
+	if err := db.Where("email = ?", req.Email).First(&user).Error; err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
@@ -77,30 +44,55 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
-	})
+	if user.EmailVerifiedAt == nil && services.EmailVerificationRequired() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email address not verified", "code": "email_unverified"})
+		return
+	}
 
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	// With two-factor authentication the password only earns a challenge
+	// that POST /auth/2fa/verify completes
+	if services.TwoFactorEnabled(db, user.ID) {
+		challenge, err := services.StartLoginChallenge(db, &user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login challenge"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"two_factor_required": true,
+			"challenge":           challenge,
+			"expires_in":          int(services.LoginChallengeTTL.Seconds()),
+		})
+		return
+	}
+
+	// Open a session with a short-lived access token and a refresh token
+	tokens, err := services.StartSession(db, &user, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": tokenString,
+	c.JSON(http.StatusOK, loginResponse(tokens, &user))
+}
+
+// loginResponse is the body of a successful login.
+func loginResponse(tokens *services.TokenPair, user *models.User) gin.H {
+	return gin.H{
+		"token":         tokens.AccessToken,
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"token_type":    tokens.TokenType,
+		"expires_in":    tokens.ExpiresIn,
 		"user": gin.H{
 			"id":       user.ID,
 			"username": user.Username,
 			"email":    user.Email,
 			"role":     user.Role,
 		},
-	})
+	}
 }
 
-func GetProfile(c *gin.Context) {
+func (h *UserHandler) GetProfile(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
@@ -110,14 +102,16 @@ func GetProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
-func UpdateProfile(c *gin.Context) {
+func (h *UserHandler) UpdateProfile(c *gin.Context) {
+	db := h.db.WithContext(c.Request.Context())
 	user, exists := c.Get("user")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
 		return
 	}
 
-	u := user.(models.User)
+	u := user.(*models.User)
+	before := *u
 	var updateData struct {
 		Username string `json:"username"`
 		Email    string `json:"email"`
@@ -133,8 +127,10 @@ func UpdateProfile(c *gin.Context) {
 	if updateData.Username != "" {
 		u.Username = updateData.Username
 	}
-	if updateData.Email != "" {
+	if updateData.Email != "" && updateData.Email != u.Email {
+		// A new address has to be verified again
 		u.Email = updateData.Email
+		u.EmailVerifiedAt = nil
 	}
 	if updateData.Password != "" {
 		u.Password = updateData.Password
@@ -144,10 +140,24 @@ func UpdateProfile(c *gin.Context) {
 		}
 	}
 
-	if err := database.DB.Save(&u).Error; err != nil {
+	if err := db.Save(u).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
 		return
 	}
 
+	// A new password logs out every other session and revokes tokens
+	if updateData.Password != "" {
+		db.Model(&models.Session{}).
+			Where("user_id = ? AND id <> ? AND revoked_at IS NULL", u.ID, c.GetUint("session_id")).
+			Update("revoked_at", time.Now())
+		services.RevokeUserAPITokens(db, u.ID)
+	}
+
+	changes := services.AuditChanges(before, *u)
+	if updateData.Password != "" {
+		changes = append(changes, models.AuditChange{Field: "password"})
+	}
+	auditChanges(c, db, services.AuditProfileUpdate, services.AuditTargetUser, u.ID, changes)
+
 	c.JSON(http.StatusOK, gin.H{"message": "Profile updated successfully"})
-} 
\ No newline at end of file
+}
@@ -1,82 +1,102 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
 	"gorm.io/gorm"
 )
 
-func Auth() gin.HandlerFunc {
+func Auth(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "authorization header is required"})
+		user, err := authenticate(c, db)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization header format"})
-			c.Abort()
-			return
-		}
+		c.Set("user", user)
+		c.Next()
+	}
+}
 
-		tokenString := parts[1]
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
+// OptionalAuth sets the user like Auth when the request carries a valid
+// token but lets anonymous requests through, for public routes that
+// personalize their results.
+func OptionalAuth(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			if user, err := authenticate(c, db); err == nil {
+				c.Set("user", user)
 			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
-
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
-			c.Abort()
-			return
 		}
+		c.Next()
+	}
+}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			userID := uint(claims["user_id"].(float64))
-			db := c.MustGet("db").(*gorm.DB)
+// authenticate resolves the user from the request's bearer token.
+func authenticate(c *gin.Context, db *gorm.DB) (*models.User, error) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		return nil, errors.New("authorization header is required")
+	}
 
-			var user models.User
-			if err := db.First(&user, userID).Error; err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
-				c.Abort()
-				return
-			}
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, errors.New("invalid authorization header format")
+	}
 
-			c.Set("user", &user)
-			c.Next()
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token claims"})
-			c.Abort()
-			return
-		}
+	if services.IsAPIToken(parts[1]) {
+		return authenticateAPIToken(c, db, parts[1])
 	}
+
+	claims, err := services.ParseAccessToken(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	// Revoked sessions lose their access tokens immediately
+	if !services.SessionActive(db, claims.UserID, claims.SessionID) {
+		return nil, services.ErrSessionRevoked
+	}
+	c.Set("session_id", claims.SessionID)
+
+	var user models.User
+	if err := db.First(&user, claims.UserID).Error; err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	return &user, nil
 }
 
-func AdminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		user, exists := c.Get("user")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
-			c.Abort()
-			return
-		}
+// authenticateAPIToken resolves the user from a personal access token,
+// which may only reach routes its scopes cover.
+func authenticateAPIToken(c *gin.Context, db *gorm.DB, plain string) (*models.User, error) {
+	token, err := services.AuthenticateAPIToken(db, plain)
+	if err != nil {
+		return nil, err
+	}
 
-		u := user.(models.User)
-		if u.Role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
-			c.Abort()
-			return
-		}
+	scope := services.RequiredScope(c.Request.Method, c.FullPath())
+	if scope == "" || !token.HasScope(scope) {
+		return nil, services.ErrScopeDenied
+	}
+	c.Set("api_token_id", token.ID)
 
-		c.Next()
+	var user models.User
+	if err := db.First(&user, token.UserID).Error; err != nil {
+		return nil, errors.New("user not found")
 	}
-} 
\ No newline at end of file
+
+	return &user, nil
+}
+
+// AdminMiddleware restricts a route group to administrators.
+func AdminMiddleware(db *gorm.DB) gin.HandlerFunc {
+	return Require(db, services.PermUserManage)
+}
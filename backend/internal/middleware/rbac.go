@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/internal/services"
+	"gorm.io/gorm"
+)
+
+// Require lets the request through only if the user's platform role grants
+// perm. It must run after Auth.
+func Require(db *gorm.DB, perm services.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u := c.MustGet("user").(*models.User)
+
+		if !services.Can(db, u, perm, nil) {
+			deny(c, db, u, perm)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireOn is Require for the problem or contest named by the :id route
+// parameter, so that ownership and per-resource grants count too. The
+// resource is stored in the context as "resource".
+func RequireOn(db *gorm.DB, perm services.Permission, resourceType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u := c.MustGet("user").(*models.User)
+
+		resource, err := services.LoadResource(db, resourceType, c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": resourceType + " not found"})
+			c.Abort()
+			return
+		}
+
+		if !services.Can(db, u, perm, resource) {
+			deny(c, db, u, perm)
+			return
+		}
+
+		c.Set("resource", resource)
+		c.Next()
+	}
+}
+
+// deny aborts with 403, telling users whose role is only held back by
+// missing two-factor authentication how to get it back.
+func deny(c *gin.Context, db *gorm.DB, u *models.User, perm services.Permission) {
+	if !services.RolePermitted(db, u) {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrTwoFactorRequired.Error(), "code": "two_factor_required"})
+	} else {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied: " + string(perm)})
+	}
+	c.Abort()
+}
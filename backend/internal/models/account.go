@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// Purposes of an AccountToken.
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+// AccountToken is a single-use token mailed to a user to verify their email
+// address or reset their password. Only its hash is stored.
+type AccountToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	Purpose   string     `json:"purpose" gorm:"not null"`
+	Email     string     `json:"email" gorm:"not null"` // address the token was sent to
+	TokenHash string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditLog records who changed what: an admin, authoring or account action,
+// the fields it changed and where the request came from. Entries are only
+// ever appended.
+type AuditLog struct {
+	ID         uint          `json:"id" gorm:"primaryKey"`
+	ActorID    *uint         `json:"actor_id" gorm:"index"`
+	Action     string        `json:"action" gorm:"not null;index"`                       // e.g. problem.update, see services.Audit*
+	TargetType string        `json:"target_type" gorm:"not null;index:idx_audit_target"` // user, problem, contest
+	TargetID   uint          `json:"target_id" gorm:"index:idx_audit_target"`
+	Changes    []AuditChange `json:"changes" gorm:"serializer:json;type:text"`
+	IP         string        `json:"ip"`
+	UserAgent  string        `json:"user_agent"`
+	CreatedAt  time.Time     `json:"created_at" gorm:"index"`
+
+	Actor *User `json:"actor,omitempty" gorm:"foreignKey:ActorID"`
+}
+
+// AuditChange is a field that an audited action changed. From is null for
+// created records and To is null for deleted ones.
+type AuditChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
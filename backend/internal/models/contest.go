@@ -15,6 +15,9 @@ type Contest struct {
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 
+	// Policy restricts submissions to every problem of the contest
+	Policy *SubmissionPolicy `json:"policy,omitempty" gorm:"serializer:json;type:text"`
+
 	// Relationships
 	Problems []Problem `json:"problems" gorm:"many2many:contest_problems;"`
 	Users    []User    `json:"users" gorm:"many2many:contest_users;"`
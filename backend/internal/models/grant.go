@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// ResourceGrant gives a user a role on a single problem or contest, such as
+// co-author of a problem or co-manager of a contest. The creator of a
+// resource is its owner without needing a grant.
+type ResourceGrant struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	ResourceType string    `json:"resource_type" gorm:"not null;uniqueIndex:idx_resource_grant"` // problem, contest
+	ResourceID   uint      `json:"resource_id" gorm:"not null;uniqueIndex:idx_resource_grant"`
+	UserID       uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_resource_grant;index"`
+	Role         string    `json:"role" gorm:"not null"` // see services.ResourceRoles
+	CreatedBy    uint      `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	User User `json:"user" gorm:"foreignKey:UserID"`
+}
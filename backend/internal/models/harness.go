@@ -0,0 +1,58 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// HarnessPlaceholder marks where a harness splices in the user's code.
+const HarnessPlaceholder = "{{solution}}"
+
+// FunctionSignature describes the function users implement in a
+// function-style problem, in language-neutral types (see
+// services.SignatureTypes).
+type FunctionSignature struct {
+	Name    string          `json:"name"`
+	Params  []FunctionParam `json:"params"`
+	Returns string          `json:"returns"`
+}
+
+type FunctionParam struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ProblemHarness turns a user's function into a complete program for one
+// language. The harness reads the arguments of a test from stdin, calls the
+// function and prints the result, so tests keep the usual input/output form.
+type ProblemHarness struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ProblemID uint      `json:"problem_id" gorm:"not null;uniqueIndex:idx_problem_harness"`
+	Language  string    `json:"language" gorm:"not null;uniqueIndex:idx_problem_harness"`
+	Template  string    `json:"template" gorm:"type:text"`   // starter code shown to users
+	Harness   string    `json:"-" gorm:"type:text;not null"` // contains HarnessPlaceholder
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Wrap returns the program to compile for code.
+func (h *ProblemHarness) Wrap(code string) string {
+	return strings.Replace(h.Harness, HarnessPlaceholder, code, 1)
+}
+
+// RevisionHarness is the copy of a ProblemHarness kept with a revision, so
+// that submissions are wrapped by the harness of the revision they are
+// judged against and rollbacks restore it.
+type RevisionHarness struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	RevisionID uint      `json:"revision_id" gorm:"not null;uniqueIndex:idx_revision_harness"`
+	Language   string    `json:"language" gorm:"not null;uniqueIndex:idx_revision_harness"`
+	Template   string    `json:"template" gorm:"type:text"`
+	Harness    string    `json:"-" gorm:"type:text;not null"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Wrap returns the program to compile for code.
+func (h *RevisionHarness) Wrap(code string) string {
+	return strings.Replace(h.Harness, HarnessPlaceholder, code, 1)
+}
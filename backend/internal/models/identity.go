@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// UserIdentity links a user to an account at an OIDC identity provider.
+type UserIdentity struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"not null;index"`
+	Provider  string    `json:"provider" gorm:"not null;uniqueIndex:idx_identity_subject"`
+	Subject   string    `json:"-" gorm:"not null;uniqueIndex:idx_identity_subject"` // the provider's "sub" claim
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLoginState remembers a login that was sent to an identity provider
+// until it comes back to the callback.
+type OIDCLoginState struct {
+	ID           uint      `gorm:"primaryKey"`
+	StateHash    string    `gorm:"not null;uniqueIndex"`
+	Provider     string    `gorm:"not null"`
+	Nonce        string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"` // PKCE
+	ReturnTo     string    // frontend path to open after login
+	ExpiresAt    time.Time `gorm:"not null"`
+	CreatedAt    time.Time
+}
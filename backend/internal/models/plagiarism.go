@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+// PlagiarismReport is one plagiarism check over the accepted submissions
+// of a contest.
+type PlagiarismReport struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	ContestID  uint       `json:"contest_id" gorm:"not null;index"`
+	Status     string     `json:"status" gorm:"not null"` // pending, done, error
+	Threshold  float64    `json:"threshold"`              // pairs below this similarity are not kept
+	Error      string     `json:"error,omitempty" gorm:"type:text"`
+	CreatedBy  uint       `json:"created_by" gorm:"not null"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+
+	Pairs []PlagiarismPair `json:"pairs,omitempty" gorm:"foreignKey:ReportID"`
+}
+
+// PlagiarismPair is two submissions to the same problem in the same
+// language whose fingerprints overlap.
+type PlagiarismPair struct {
+	ID          uint          `json:"id" gorm:"primaryKey"`
+	ReportID    uint          `json:"report_id" gorm:"not null;index"`
+	ProblemID   uint          `json:"problem_id" gorm:"not null"`
+	Language    string        `json:"language" gorm:"not null"`
+	SubmissionA uint          `json:"submission_a" gorm:"not null"`
+	SubmissionB uint          `json:"submission_b" gorm:"not null"`
+	UserA       uint          `json:"user_a" gorm:"not null"`
+	UserB       uint          `json:"user_b" gorm:"not null"`
+	Similarity  float64       `json:"similarity" gorm:"index"` // 0 to 1
+	Matches     []MatchRegion `json:"matches,omitempty" gorm:"serializer:json;type:text"`
+}
+
+// MatchRegion is a run of matching code, as 1-based inclusive line ranges
+// in the sources of both submissions.
+type MatchRegion struct {
+	StartA int `json:"start_a"`
+	EndA   int `json:"end_a"`
+	StartB int `json:"start_b"`
+	EndB   int `json:"end_b"`
+}
@@ -0,0 +1,10 @@
+package models
+
+// SubmissionPolicy restricts submissions to a problem, or to every problem
+// of a contest. Zero values mean no restriction.
+type SubmissionPolicy struct {
+	Languages      []string `json:"languages,omitempty"`       // allowed languages
+	MaxSourceSize  int      `json:"max_source_size,omitempty"` // bytes, all files together
+	MinInterval    int      `json:"min_interval,omitempty"`    // seconds between a user's submissions
+	MaxSubmissions int      `json:"max_submissions,omitempty"` // per user and problem
+}
@@ -9,25 +9,60 @@ type Problem struct {
 	Title       string    `json:"title" gorm:"not null"`
 	Description string    `json:"description" gorm:"type:text;not null"`
 	Difficulty  string    `json:"difficulty" gorm:"not null"` // easy, medium, hard
-	TimeLimit   int       `json:"time_limit" gorm:"not null"` // in milliseconds
-	MemoryLimit int       `json:"memory_limit" gorm:"not null"` // in MB
+	Points      int       `json:"points" gorm:"default:0;index"`
+	TimeLimit   int       `json:"time_limit" gorm:"not null"`     // in milliseconds
+	MemoryLimit int       `json:"memory_limit" gorm:"not null"`   // in MB
+	Checker     string    `json:"checker" gorm:"default:'exact'"` // exact, tokens
+	RevisionID  uint      `json:"revision_id"`                    // current ProblemRevision
+	TestScript  string    `json:"test_script" gorm:"type:text"`   // generator invocations, one test per line
 	CreatedBy   uint      `json:"created_by" gorm:"not null"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
-	
+
+	// Signature is set for function-style problems, see ProblemHarness
+	Signature *FunctionSignature `json:"signature,omitempty" gorm:"serializer:json;type:text"`
+
+	// How programs read tests and produce answers, see IOStdio
+	IOMode     string `json:"io_mode" gorm:"default:'stdio'"`
+	InputFile  string `json:"input_file,omitempty"`  // IOFiles only, defaults to input.txt
+	OutputFile string `json:"output_file,omitempty"` // IOFiles only, defaults to output.txt
+
+	// Policy restricts submissions, see SubmissionPolicy
+	Policy *SubmissionPolicy `json:"policy,omitempty" gorm:"serializer:json;type:text"`
+
 	// Relationships
-	TestCases []TestCase `json:"test_cases" gorm:"foreignKey:ProblemID"`
-	Tags      []Tag      `json:"tags" gorm:"many2many:problem_tags;"`
+	TestCases []TestCase       `json:"test_cases" gorm:"foreignKey:ProblemID"`
+	Tags      []Tag            `json:"tags" gorm:"many2many:problem_tags;"`
+	Programs  []ProblemProgram `json:"programs,omitempty" gorm:"foreignKey:ProblemID"`
+	Harnesses []ProblemHarness `json:"-" gorm:"foreignKey:ProblemID"`
+
+	// Statement is the translation selected for the request, see GetProblem
+	Statement *ProblemStatement `json:"statement,omitempty" gorm:"-"`
+
+	// StarterCode maps languages to the template of their harness
+	StarterCode map[string]string `json:"starter_code,omitempty" gorm:"-"`
 }
 
+// I/O modes of a problem.
+const (
+	IOStdio      = "stdio"       // read stdin, write stdout
+	IOFiles      = "files"       // read InputFile, write OutputFile in the working directory
+	IOOutputOnly = "output_only" // submit a zip of precomputed answers, one "<n>.out" per test
+)
+
+// LanguageOutputOnly is the submission language of answer archives sent to
+// IOOutputOnly problems.
+const LanguageOutputOnly = "output"
+
 type TestCase struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	ProblemID uint      `json:"problem_id" gorm:"not null"`
-	Input     string    `json:"input" gorm:"type:text;not null"`
-	Output    string    `json:"output" gorm:"type:text;not null"`
-	IsSample  bool      `json:"is_sample" gorm:"default:false"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	ProblemID  uint      `json:"problem_id" gorm:"not null"`
+	RevisionID uint      `json:"revision_id" gorm:"index"`
+	Input      string    `json:"input" gorm:"type:text;not null"`
+	Output     string    `json:"output" gorm:"type:text;not null"`
+	IsSample   bool      `json:"is_sample" gorm:"default:false"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
 }
 
 type Tag struct {
@@ -35,4 +70,4 @@ type Tag struct {
 	Name      string    `json:"name" gorm:"unique;not null"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
-} 
\ No newline at end of file
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	ProgramValidator = "validator" // checks every test input, exits non-zero on invalid input
+	ProgramGenerator = "generator" // prints a test input for the arguments given in the test script
+	ProgramSolution  = "solution"  // reference solution verified against every revision
+)
+
+// ExpectMain tags the main correct solution. Its output is the answer for
+// generated tests and its running time drives the suggested time limit.
+const ExpectMain = "main"
+
+// SolutionExpectations maps the outcome a reference solution can be tagged
+// with to the submission status it must produce.
+var SolutionExpectations = map[string]string{
+	ExpectMain:      "accepted",
+	"accepted":      "accepted",
+	"wrong_answer":  "wrong_answer",
+	"time_limit":    "time_limit",
+	"runtime_error": "runtime_error",
+}
+
+// ProblemProgram is an authoring program attached to a problem. Programs are
+// never run against user submissions, only when the problem is saved.
+type ProblemProgram struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ProblemID uint      `json:"problem_id" gorm:"not null;index"`
+	Kind      string    `json:"kind" gorm:"not null"` // validator, generator, solution
+	Name      string    `json:"name" gorm:"not null"` // referenced by the test script for generators
+	Language  string    `json:"language" gorm:"not null"`
+	Source    string    `json:"source" gorm:"type:text;not null"`
+	Expected  string    `json:"expected,omitempty"` // solutions only, see SolutionExpectations
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SolutionCheck is the outcome of running one reference solution against the
+// tests of a revision.
+type SolutionCheck struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	RevisionID uint      `json:"revision_id" gorm:"not null;index"`
+	ProgramID  uint      `json:"program_id" gorm:"not null"`
+	Name       string    `json:"name"`
+	Expected   string    `json:"expected"`
+	Verdict    string    `json:"verdict"`     // submission status the solution produced
+	FailedTest int       `json:"failed_test"` // 1-based, 0 when every test passed
+	MaxTime    int       `json:"max_time"`    // in milliseconds
+	Matches    bool      `json:"matches"`     // verdict agrees with Expected
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+)
+
+// Test statuses of a ProblemRevision.
+const (
+	TestsGenerating = "generating" // test script and validator not run yet
+	TestsReady      = "ready"      // tests are final
+	TestsFailed     = "failed"     // generation or validation failed, see TestError
+)
+
+// ProblemRevision is an immutable snapshot of a problem taken on every save.
+// Submissions record the revision they were judged against so later edits
+// never change the meaning of an old verdict.
+type ProblemRevision struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	ProblemID   uint      `json:"problem_id" gorm:"not null;uniqueIndex:idx_problem_revision"`
+	Number      int       `json:"number" gorm:"not null;uniqueIndex:idx_problem_revision"`
+	Title       string    `json:"title" gorm:"not null"`
+	Description string    `json:"description" gorm:"type:text;not null"`
+	Difficulty  string    `json:"difficulty" gorm:"not null"`
+	TimeLimit   int       `json:"time_limit" gorm:"not null"`   // in milliseconds
+	MemoryLimit int       `json:"memory_limit" gorm:"not null"` // in MB
+	Checker     string    `json:"checker"`
+	Message     string    `json:"message"` // optional note describing the change
+	CreatedBy   uint      `json:"created_by" gorm:"not null"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	IOMode     string `json:"io_mode"`
+	InputFile  string `json:"input_file,omitempty"`
+	OutputFile string `json:"output_file,omitempty"`
+
+	Points     int                `json:"points"`
+	TestScript string             `json:"test_script,omitempty" gorm:"type:text"`
+	Signature  *FunctionSignature `json:"signature,omitempty" gorm:"serializer:json;type:text"`
+	Policy     *SubmissionPolicy  `json:"policy,omitempty" gorm:"serializer:json;type:text"`
+
+	// Generated and validated tests are added by the judge after the
+	// revision is saved; submissions wait until TestStatus is ready
+	TestStatus string `json:"test_status" gorm:"not null;default:ready"`
+	TestError  string `json:"test_error,omitempty" gorm:"type:text"`
+
+	// Reference solution verification, filled in asynchronously
+	VerificationStatus string `json:"verification_status"`  // "", pending, passed, failed, error
+	SuggestedTimeLimit int    `json:"suggested_time_limit"` // in milliseconds, from the main solution
+
+	// Relationships
+	TestCases      []TestCase         `json:"test_cases,omitempty" gorm:"foreignKey:RevisionID"`
+	Statements     []ProblemStatement `json:"statements,omitempty" gorm:"foreignKey:RevisionID"`
+	SolutionChecks []SolutionCheck    `json:"solution_checks,omitempty" gorm:"foreignKey:RevisionID"`
+	Harnesses      []RevisionHarness  `json:"harnesses,omitempty" gorm:"foreignKey:RevisionID"`
+}
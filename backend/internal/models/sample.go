@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+)
+
+// SampleRun is a solution judged on the sample tests of a problem only. It
+// is stored apart from Submission so it never counts toward statistics or
+// contest penalties.
+type SampleRun struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	UserID     uint      `json:"user_id" gorm:"not null;index"`
+	ProblemID  uint      `json:"problem_id" gorm:"not null;index"`
+	ContestID  *uint     `json:"contest_id"`
+	RevisionID uint      `json:"revision_id"`
+	Language   string    `json:"language" gorm:"not null"`
+	Code       string    `json:"code" gorm:"type:text;not null"`
+	Status     string    `json:"status" gorm:"not null"` // same verdicts as Submission
+	Error      string    `json:"error,omitempty" gorm:"type:text"`
+	TimeUsed   int       `json:"time_used"`   // in milliseconds, slowest sample
+	MemoryUsed int       `json:"memory_used"` // in KB, largest sample
+	CreatedAt  time.Time `json:"created_at"`
+
+	Files map[string]string `json:"files,omitempty" gorm:"serializer:json;type:text"` // see Submission.Files
+
+	Results []SampleResult `json:"results" gorm:"foreignKey:SampleRunID"`
+}
+
+// SampleResult is the outcome of one sample test, with both outputs kept so
+// the user can see where they differ.
+type SampleResult struct {
+	ID            uint   `json:"id" gorm:"primaryKey"`
+	SampleRunID   uint   `json:"sample_run_id" gorm:"not null;index"`
+	TestCaseID    uint   `json:"test_case_id" gorm:"not null"`
+	Status        string `json:"status" gorm:"not null"`
+	Input         string `json:"input" gorm:"type:text"`
+	Expected      string `json:"expected" gorm:"type:text"`
+	Actual        string `json:"actual" gorm:"type:text"`
+	Stderr        string `json:"stderr,omitempty" gorm:"type:text"`
+	FirstDiffLine int    `json:"first_diff_line,omitempty"` // 1-based, 0 when outputs match
+	TimeUsed      int    `json:"time_used"`                 // in milliseconds
+	MemoryUsed    int    `json:"memory_used"`               // in KB
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is a login on one device. Its refresh token is rotated on every
+// use and only its hash is stored; access tokens name the session so that
+// revoking it cuts them off too.
+type Session struct {
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	UserID            uint       `json:"user_id" gorm:"not null;index"`
+	RefreshTokenHash  string     `json:"-" gorm:"not null;uniqueIndex"`
+	PreviousTokenHash string     `json:"-" gorm:"index"` // the rotated-out token, to detect reuse
+	UserAgent         string     `json:"user_agent"`
+	IP                string     `json:"ip"`
+	ExpiresAt         time.Time  `json:"expires_at" gorm:"not null"`
+	LastUsedAt        time.Time  `json:"last_used_at"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// Active reports whether the session can still be used at t.
+func (s *Session) Active(t time.Time) bool {
+	return s.RevokedAt == nil && t.Before(s.ExpiresAt)
+}
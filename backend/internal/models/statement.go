@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// ProblemStatement is one translation of a problem statement. Sections are
+// Markdown and may contain KaTeX math between $...$ or $$...$$. Statements
+// are versioned with the revision they belong to, like test cases.
+type ProblemStatement struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	ProblemID    uint      `json:"problem_id" gorm:"not null"`
+	RevisionID   uint      `json:"revision_id" gorm:"index"`
+	Language     string    `json:"language" gorm:"not null"` // BCP 47 tag, e.g. "en", "fa"
+	Title        string    `json:"title" gorm:"not null"`
+	Legend       string    `json:"legend" gorm:"type:text"`
+	InputFormat  string    `json:"input_format" gorm:"type:text"`
+	OutputFormat string    `json:"output_format" gorm:"type:text"`
+	Notes        string    `json:"notes" gorm:"type:text"`
+	Scoring      string    `json:"scoring" gorm:"type:text"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ProblemAttachment is an image or file referenced from a statement. The
+// content lives in blob storage under Key; statements link to it by Name.
+type ProblemAttachment struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	ProblemID   uint      `json:"problem_id" gorm:"not null;uniqueIndex:idx_problem_attachment"`
+	Name        string    `json:"name" gorm:"not null;uniqueIndex:idx_problem_attachment"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Key         string    `json:"-" gorm:"not null"`
+	CreatedBy   uint      `json:"created_by" gorm:"not null"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
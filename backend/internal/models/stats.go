@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+)
+
+// ProblemStats holds per-problem aggregates maintained incrementally as
+// submissions are judged, so listing and sorting problems stays cheap.
+type ProblemStats struct {
+	ProblemID      uint      `json:"problem_id" gorm:"primaryKey;autoIncrement:false"`
+	Submissions    int       `json:"submissions" gorm:"not null;default:0"` // judged submissions
+	Accepted       int       `json:"accepted" gorm:"not null;default:0"`
+	Solvers        int       `json:"solvers" gorm:"not null;default:0;index"` // distinct users with an accepted submission
+	AcceptanceRate float64   `json:"acceptance_rate" gorm:"not null;default:0;index"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ProblemVerdictCount counts judged submissions of a problem by status.
+type ProblemVerdictCount struct {
+	ProblemID uint   `json:"problem_id" gorm:"primaryKey;autoIncrement:false"`
+	Status    string `json:"status" gorm:"primaryKey"`
+	Count     int    `json:"count" gorm:"not null;default:0"`
+}
+
+// ProblemLanguageStats aggregates the accepted runs of a problem in one
+// language.
+type ProblemLanguageStats struct {
+	ProblemID   uint   `json:"problem_id" gorm:"primaryKey;autoIncrement:false"`
+	Language    string `json:"language" gorm:"primaryKey"`
+	Accepted    int    `json:"accepted" gorm:"not null;default:0"`
+	TotalTime   int64  `json:"-" gorm:"not null;default:0"` // in milliseconds
+	TotalMemory int64  `json:"-" gorm:"not null;default:0"` // in KB
+}
+
+// ProblemRunBucket is one histogram bucket of accepted run times or memory
+// usage for a problem and language. Bucket is the inclusive lower bound.
+type ProblemRunBucket struct {
+	ProblemID uint   `json:"problem_id" gorm:"primaryKey;autoIncrement:false"`
+	Language  string `json:"language" gorm:"primaryKey"`
+	Metric    string `json:"metric" gorm:"primaryKey"` // time, memory
+	Bucket    int    `json:"bucket" gorm:"primaryKey;autoIncrement:false"`
+	Count     int    `json:"count" gorm:"not null;default:0"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// TwoFactor holds a user's TOTP secret. It takes effect once EnabledAt is
+// set, after the user proved their authenticator app works.
+type TwoFactor struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	UserID       uint       `json:"user_id" gorm:"not null;uniqueIndex"`
+	Secret       string     `json:"-" gorm:"not null"` // base32
+	LastUsedStep int64      `json:"-"`                 // time step of the last accepted code, against replays
+	EnabledAt    *time.Time `json:"enabled_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// RecoveryCode is a single-use code that stands in for a TOTP code when
+// the authenticator is lost.
+type RecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	CodeHash  string `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// LoginChallenge is the second step of a login for a user with two-factor
+// authentication. The password was correct; a code is still needed.
+type LoginChallenge struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	TokenHash string    `gorm:"not null;uniqueIndex"`
+	Attempts  int       `gorm:"not null;default:0"`
+	ExpiresAt time.Time `gorm:"not null"`
+	CreatedAt time.Time
+}
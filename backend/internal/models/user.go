@@ -15,6 +15,8 @@ type User struct {
 	Rating    int       `json:"rating" gorm:"default:0"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
 }
 
 // HashPassword hashes the user's password
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/pkg/mailer"
+	"gorm.io/gorm"
+)
+
+const (
+	VerifyEmailTTL   = 48 * time.Hour
+	ResetPasswordTTL = time.Hour
+
+	// A new token of the same purpose is mailed at most this often per user.
+	accountTokenResendInterval = time.Minute
+)
+
+var (
+	ErrInvalidAccountToken = errors.New("invalid or expired token")
+	ErrEmailVerified       = errors.New("email address already verified")
+)
+
+// EmailVerificationRequired reports whether unverified accounts are kept
+// from logging in. It is on unless REQUIRE_EMAIL_VERIFICATION is "false".
+func EmailVerificationRequired() bool {
+	return os.Getenv("REQUIRE_EMAIL_VERIFICATION") != "false"
+}
+
+// appURL is where the frontend serves the pages that mailed links open.
+func appURL() string {
+	if u := os.Getenv("APP_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "http://localhost:3000"
+}
+
+// issueAccountToken creates a token for purpose, voiding the user's earlier
+// unused ones. It returns "" without error when one was issued too recently.
+func issueAccountToken(db *gorm.DB, user *models.User, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	var recent int64
+	db.Model(&models.AccountToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", user.ID, purpose, now.Add(-accountTokenResendInterval)).
+		Count(&recent)
+	if recent > 0 {
+		return "", nil
+	}
+
+	plain, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.AccountToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", user.ID, purpose).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.AccountToken{
+			UserID:    user.ID,
+			Purpose:   purpose,
+			Email:     user.Email,
+			TokenHash: hashToken(plain),
+			ExpiresAt: now.Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// consumeAccountToken marks a token used and returns it. Each token works
+// once, so two requests racing with the same token cannot both succeed.
+func consumeAccountToken(db *gorm.DB, plain, purpose string) (*models.AccountToken, error) {
+	var token models.AccountToken
+	if err := db.Where("token_hash = ? AND purpose = ?", hashToken(plain), purpose).First(&token).Error; err != nil {
+		return nil, ErrInvalidAccountToken
+	}
+
+	now := time.Now()
+	result := db.Model(&token).
+		Where("used_at IS NULL AND expires_at > ?", now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidAccountToken
+	}
+	return &token, nil
+}
+
+// SendVerificationEmail mails user a link to verify their email address.
+func SendVerificationEmail(ctx context.Context, db *gorm.DB, m mailer.Mailer, user *models.User) error {
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailVerified
+	}
+
+	plain, err := issueAccountToken(db, user, models.TokenVerifyEmail, VerifyEmailTTL)
+	if err != nil || plain == "" {
+		return err
+	}
+
+	link := appURL() + "/verify-email?token=" + url.QueryEscape(plain)
+	return m.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link to verify your email address:\n\n%s\n\n"+
+			"The link expires in %d hours. If you did not create an account, ignore this email.\n",
+			user.Username, link, int(VerifyEmailTTL.Hours())),
+	})
+}
+
+// VerifyEmail marks the address a verification token was sent to as
+// verified, provided the user still has that address.
+func VerifyEmail(db *gorm.DB, plain string) (*models.User, error) {
+	token, err := consumeAccountToken(db, plain, models.TokenVerifyEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := db.First(&user, token.UserID).Error; err != nil || user.Email != token.Email {
+		return nil, ErrInvalidAccountToken
+	}
+
+	now := time.Now()
+	if err := db.Model(&user).Update("email_verified_at", now).Error; err != nil {
+		return nil, err
+	}
+	user.EmailVerifiedAt = &now
+	return &user, nil
+}
+
+// RequestPasswordReset mails a password reset link to the account with
+// email. Unknown addresses are ignored so that callers cannot probe which
+// accounts exist.
+func RequestPasswordReset(ctx context.Context, db *gorm.DB, m mailer.Mailer, email string) error {
+	var user models.User
+	if err := db.Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	plain, err := issueAccountToken(db, &user, models.TokenResetPassword, ResetPasswordTTL)
+	if err != nil || plain == "" {
+		return err
+	}
+
+	link := appURL() + "/reset-password?token=" + url.QueryEscape(plain)
+	return m.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link to choose a new password:\n\n%s\n\n"+
+			"The link expires in %d minutes. If you did not ask for this, ignore this email.\n",
+			user.Username, link, int(ResetPasswordTTL.Minutes())),
+	})
+}
+
+// ResetPassword sets a new password with a reset token, logs the user out
+// everywhere and returns them. Receiving the token proves ownership of the address, so
+// it is verified too.
+func ResetPassword(db *gorm.DB, plain, password string) (*models.User, error) {
+	token, err := consumeAccountToken(db, plain, models.TokenResetPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := db.First(&user, token.UserID).Error; err != nil || user.Email != token.Email {
+		return nil, ErrInvalidAccountToken
+	}
+
+	user.Password = password
+	if err := user.HashPassword(); err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{"password": user.Password}
+	if user.EmailVerifiedAt == nil {
+		updates["email_verified_at"] = time.Now()
+	}
+	if err := db.Model(&user).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, RevokeUserAccess(db, user.ID)
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/pkg/mailer"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns an empty in-memory database with the given models
+// migrated. Each test gets its own database.
+func newTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func newAccountTestDB(t *testing.T) *gorm.DB {
+	return newTestDB(t, &models.User{}, &models.AccountToken{}, &models.Session{}, &models.APIToken{})
+}
+
+func createTestUser(t *testing.T, db *gorm.DB, username string) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, Email: username + "@example.com", Password: "secret123"}
+	if err := user.HashPassword(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+func TestConsumeAccountToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(db *gorm.DB, plain string) // runs after the token is issued
+		consume string                          // purpose to consume with
+		wantErr error
+	}{
+		{
+			name:    "valid",
+			consume: models.TokenVerifyEmail,
+		},
+		{
+			name: "used",
+			setup: func(db *gorm.DB, plain string) {
+				consumeAccountToken(db, plain, models.TokenVerifyEmail)
+			},
+			consume: models.TokenVerifyEmail,
+			wantErr: ErrInvalidAccountToken,
+		},
+		{
+			name: "expired",
+			setup: func(db *gorm.DB, plain string) {
+				db.Model(&models.AccountToken{}).Where("token_hash = ?", hashToken(plain)).
+					Update("expires_at", time.Now().Add(-time.Second))
+			},
+			consume: models.TokenVerifyEmail,
+			wantErr: ErrInvalidAccountToken,
+		},
+		{
+			name:    "other purpose",
+			consume: models.TokenResetPassword,
+			wantErr: ErrInvalidAccountToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newAccountTestDB(t)
+			user := createTestUser(t, db, "alice")
+
+			plain, err := issueAccountToken(db, user, models.TokenVerifyEmail, VerifyEmailTTL)
+			if err != nil || plain == "" {
+				t.Fatalf("issueAccountToken() = %q, %v", plain, err)
+			}
+			if tt.setup != nil {
+				tt.setup(db, plain)
+			}
+
+			token, err := consumeAccountToken(db, plain, tt.consume)
+			if err != tt.wantErr {
+				t.Fatalf("consumeAccountToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && token.UserID != user.ID {
+				t.Errorf("token belongs to user %d, want %d", token.UserID, user.ID)
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		db := newAccountTestDB(t)
+		if _, err := consumeAccountToken(db, "not-a-token", models.TokenVerifyEmail); err != ErrInvalidAccountToken {
+			t.Errorf("consumeAccountToken() error = %v, want %v", err, ErrInvalidAccountToken)
+		}
+	})
+}
+
+func TestIssueAccountTokenResend(t *testing.T) {
+	db := newAccountTestDB(t)
+	user := createTestUser(t, db, "alice")
+
+	first, err := issueAccountToken(db, user, models.TokenResetPassword, ResetPasswordTTL)
+	if err != nil || first == "" {
+		t.Fatalf("first issueAccountToken() = %q, %v", first, err)
+	}
+
+	// Within the resend interval nothing new is issued
+	again, err := issueAccountToken(db, user, models.TokenResetPassword, ResetPasswordTTL)
+	if err != nil || again != "" {
+		t.Fatalf("issueAccountToken() within the resend interval = %q, %v, want no token", again, err)
+	}
+
+	// Other purposes are throttled separately
+	verify, err := issueAccountToken(db, user, models.TokenVerifyEmail, VerifyEmailTTL)
+	if err != nil || verify == "" {
+		t.Fatalf("issueAccountToken() for another purpose = %q, %v", verify, err)
+	}
+
+	// Once the interval passes a new token replaces the first
+	db.Model(&models.AccountToken{}).Where("user_id = ?", user.ID).
+		Update("created_at", time.Now().Add(-accountTokenResendInterval-time.Second))
+	second, err := issueAccountToken(db, user, models.TokenResetPassword, ResetPasswordTTL)
+	if err != nil || second == "" || second == first {
+		t.Fatalf("issueAccountToken() after the resend interval = %q, %v", second, err)
+	}
+	if _, err := consumeAccountToken(db, first, models.TokenResetPassword); err != ErrInvalidAccountToken {
+		t.Errorf("replaced token error = %v, want %v", err, ErrInvalidAccountToken)
+	}
+	if _, err := consumeAccountToken(db, second, models.TokenResetPassword); err != nil {
+		t.Errorf("new token error = %v", err)
+	}
+	if _, err := consumeAccountToken(db, verify, models.TokenVerifyEmail); err != nil {
+		t.Errorf("token of another purpose error = %v, want it left alone", err)
+	}
+}
+
+var resetLink = regexp.MustCompile(`/reset-password\?token=(\S+)`)
+
+// mailedTokens returns the reset tokens in the messages written to dir.
+func mailedTokens(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := resetLink.FindSubmatch(data)
+		if m == nil {
+			t.Fatalf("no reset link in %s:\n%s", file, data)
+		}
+		token, err := url.QueryUnescape(string(m[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+func TestResetPassword(t *testing.T) {
+	db := newAccountTestDB(t)
+	user := createTestUser(t, db, "alice")
+	dir := t.TempDir()
+	m, err := mailer.NewFileMailer(dir, "noreply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := models.Session{UserID: user.ID, RefreshTokenHash: "refresh", ExpiresAt: time.Now().Add(time.Hour)}
+	apiToken := models.APIToken{UserID: user.ID, Name: "ci", Prefix: "pat", TokenHash: "pat", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := db.Create(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&apiToken).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := RequestPasswordReset(ctx, db, m, "nobody@example.com"); err != nil {
+		t.Fatalf("RequestPasswordReset() for an unknown address = %v", err)
+	}
+	if tokens := mailedTokens(t, dir); len(tokens) != 0 {
+		t.Fatalf("mailed %d messages for an unknown address", len(tokens))
+	}
+
+	if err := RequestPasswordReset(ctx, db, m, user.Email); err != nil {
+		t.Fatalf("RequestPasswordReset() = %v", err)
+	}
+	tokens := mailedTokens(t, dir)
+	if len(tokens) != 1 {
+		t.Fatalf("mailed %d messages, want 1", len(tokens))
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"wrong token", "not-a-token", ErrInvalidAccountToken},
+		{"mailed token", tokens[0], nil},
+		{"reused token", tokens[0], ErrInvalidAccountToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ResetPassword(db, tt.token, "new-secret456")
+			if err != tt.wantErr {
+				t.Fatalf("ResetPassword() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	var updated models.User
+	db.First(&updated, user.ID)
+	if err := updated.CheckPassword("new-secret456"); err != nil {
+		t.Error("password was not changed")
+	}
+	if updated.EmailVerifiedAt == nil {
+		t.Error("resetting the password should verify the address")
+	}
+
+	db.First(&session, session.ID)
+	db.First(&apiToken, apiToken.ID)
+	if session.RevokedAt == nil {
+		t.Error("session was not revoked")
+	}
+	if apiToken.RevokedAt == nil {
+		t.Error("personal access token was not revoked")
+	}
+}
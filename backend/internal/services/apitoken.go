@@ -0,0 +1,172 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// APITokenPrefix marks personal access tokens so that Auth can tell them
+	// from session access tokens.
+	APITokenPrefix = "oj_pat_"
+
+	DefaultAPITokenTTL = 30 * 24 * time.Hour
+	MaxAPITokenTTL     = 365 * 24 * time.Hour
+
+	// Last use is recorded at most this often to spare a write per request.
+	apiTokenTouchInterval = time.Minute
+)
+
+// Scopes a personal access token can be granted. Reads cover GET routes of
+// the resource, writes everything else.
+const (
+	ScopeProblemsRead     = "problems:read"
+	ScopeProblemsWrite    = "problems:write"
+	ScopeContestsRead     = "contests:read"
+	ScopeContestsWrite    = "contests:write"
+	ScopeSubmissionsRead  = "submissions:read"
+	ScopeSubmissionsWrite = "submissions:write"
+	ScopeProfileRead      = "profile:read"
+)
+
+var APITokenScopes = map[string]bool{
+	ScopeProblemsRead:     true,
+	ScopeProblemsWrite:    true,
+	ScopeContestsRead:     true,
+	ScopeContestsWrite:    true,
+	ScopeSubmissionsRead:  true,
+	ScopeSubmissionsWrite: true,
+	ScopeProfileRead:      true,
+}
+
+var (
+	ErrInvalidAPIToken = errors.New("invalid api token")
+	ErrAPITokenExpired = errors.New("api token has expired or been revoked")
+	ErrUnknownScope    = errors.New("unknown scope")
+	ErrNoScopes        = errors.New("at least one scope is required")
+	ErrScopeDenied     = errors.New("api token does not allow this request")
+)
+
+// scopeResources maps the first path segment under /api to the scope
+// resource that covers it. Routes not listed here, such as session and
+// token management, cannot be reached with a personal access token.
+var scopeResources = map[string]string{
+	"problems":    "problems",
+	"contests":    "contests",
+	"submissions": "submissions",
+	"sample-runs": "submissions",
+	"run":         "submissions",
+}
+
+// IsAPIToken reports whether a bearer token is a personal access token.
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// ValidateScopes checks that scopes is non-empty and only names known scopes.
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return ErrNoScopes
+	}
+	for _, s := range scopes {
+		if !APITokenScopes[s] {
+			return ErrUnknownScope
+		}
+	}
+	return nil
+}
+
+// CreateAPIToken issues a personal access token for user. The plain token is
+// returned only here; it cannot be recovered later.
+func CreateAPIToken(db *gorm.DB, user *models.User, name string, scopes []string, ttl time.Duration) (*models.APIToken, string, error) {
+	if err := ValidateScopes(scopes); err != nil {
+		return nil, "", err
+	}
+	if ttl <= 0 {
+		ttl = DefaultAPITokenTTL
+	}
+	if ttl > MaxAPITokenTTL {
+		return nil, "", errors.New("api tokens can be valid for at most 365 days")
+	}
+
+	secret, err := newSecretToken()
+	if err != nil {
+		return nil, "", err
+	}
+	plain := APITokenPrefix + secret
+
+	token := models.APIToken{
+		UserID:    user.ID,
+		Name:      name,
+		Prefix:    plain[:len(APITokenPrefix)+6],
+		TokenHash: hashToken(plain),
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := db.Create(&token).Error; err != nil {
+		return nil, "", err
+	}
+	return &token, plain, nil
+}
+
+// AuthenticateAPIToken resolves a personal access token and records its use.
+func AuthenticateAPIToken(db *gorm.DB, plain string) (*models.APIToken, error) {
+	var token models.APIToken
+	if err := db.Where("token_hash = ?", hashToken(plain)).First(&token).Error; err != nil {
+		return nil, ErrInvalidAPIToken
+	}
+
+	now := time.Now()
+	if !token.Active(now) {
+		return nil, ErrAPITokenExpired
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > apiTokenTouchInterval {
+		db.Model(&token).Update("last_used_at", now)
+	}
+	return &token, nil
+}
+
+// RequiredScope returns the scope a request to the route fullPath with
+// method needs, or "" if personal access tokens may not use the route.
+func RequiredScope(method, fullPath string) string {
+	parts := strings.Split(strings.TrimPrefix(fullPath, "/api/"), "/")
+
+	// The only account route open to tokens is reading the own profile
+	if fullPath == "/api/users/me" && method == "GET" {
+		return ScopeProfileRead
+	}
+
+	resource, ok := scopeResources[parts[0]]
+	if !ok {
+		return ""
+	}
+	if method == "GET" || method == "HEAD" {
+		return resource + ":read"
+	}
+	return resource + ":write"
+}
+
+// RevokeUserAPITokens revokes every token of a user.
+func RevokeUserAPITokens(db *gorm.DB, userID uint) error {
+	return db.Model(&models.APIToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAPIToken revokes one of the user's tokens.
+func RevokeAPIToken(db *gorm.DB, userID, tokenID uint) error {
+	result := db.Model(&models.APIToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
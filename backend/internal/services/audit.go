@@ -0,0 +1,207 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// AuditTargetUser is the audit target type of account actions. Problems and
+// contests use ResourceProblem and ResourceContest.
+const AuditTargetUser = "user"
+
+// Audited actions, named <target type>.<verb>.
+const (
+	AuditUserRegister            = "user.register"
+	AuditUserUpdate              = "user.update" // by an admin
+	AuditUserDelete              = "user.delete"
+	AuditProfileUpdate           = "user.profile_update"
+	AuditPasswordReset           = "user.password_reset"
+	AuditTwoFactorEnable         = "user.2fa_enable"
+	AuditTwoFactorDisable        = "user.2fa_disable"
+	AuditRecoveryCodesRegenerate = "user.recovery_codes_regenerate"
+	AuditAPITokenCreate          = "user.token_create"
+	AuditAPITokenRevoke          = "user.token_revoke"
+	AuditProblemCreate           = "problem.create"
+	AuditProblemUpdate           = "problem.update"
+	AuditProblemDelete           = "problem.delete"
+	AuditProblemRollback         = "problem.rollback"
+	AuditProblemRejudge          = "problem.rejudge"
+	AuditAttachmentUpload        = "problem.attachment_upload"
+	AuditAttachmentDelete        = "problem.attachment_delete"
+	AuditProblemGrantAdd         = "problem.grant_add"
+	AuditProblemGrantRemove      = "problem.grant_remove"
+	AuditContestCreate           = "contest.create"
+	AuditContestUpdate           = "contest.update"
+	AuditContestDelete           = "contest.delete"
+	AuditContestGrantAdd         = "contest.grant_add"
+	AuditContestGrantRemove      = "contest.grant_remove"
+	AuditPlagiarismCheck         = "contest.plagiarism_check"
+)
+
+// auditIgnoredFields change on every save and would only add noise.
+var auditIgnoredFields = map[string]bool{
+	"updated_at": true,
+}
+
+// auditFields flattens a record to its JSON fields.
+func auditFields(v interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return fields
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// AuditChanges compares the JSON fields of a record before and after an
+// action. Pass nil as before for created records and as after for deleted
+// ones. Fields tagged json:"-", such as password hashes, are never recorded.
+func AuditChanges(before, after interface{}) []models.AuditChange {
+	from, to := auditFields(before), auditFields(after)
+
+	names := make([]string, 0, len(from)+len(to))
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []models.AuditChange{}
+	for _, name := range names {
+		if auditIgnoredFields[name] {
+			continue
+		}
+		if a, b := from[name], to[name]; !reflect.DeepEqual(a, b) {
+			changes = append(changes, models.AuditChange{Field: name, From: a, To: b})
+		}
+	}
+	return changes
+}
+
+// AuditProblem is what the audit log records of a problem at a revision.
+// Tests and statements are recorded as a count and a digest, so that the
+// log shows they changed and the revision diff shows how.
+func AuditProblem(problem *models.Problem, revision *models.ProblemRevision) map[string]interface{} {
+	tags := make([]string, 0, len(problem.Tags))
+	for _, tag := range problem.Tags {
+		tags = append(tags, tag.Name)
+	}
+	sort.Strings(tags)
+
+	snapshot := map[string]interface{}{
+		"title":        problem.Title,
+		"description":  problem.Description,
+		"difficulty":   problem.Difficulty,
+		"points":       problem.Points,
+		"time_limit":   problem.TimeLimit,
+		"memory_limit": problem.MemoryLimit,
+		"checker":      problem.Checker,
+		"io_mode":      problem.IOMode,
+		"input_file":   problem.InputFile,
+		"output_file":  problem.OutputFile,
+		"test_script":  problem.TestScript,
+		"signature":    problem.Signature,
+		"policy":       problem.Policy,
+		"tags":         tags,
+	}
+	if revision == nil {
+		return snapshot
+	}
+
+	tests := sha256.New()
+	for _, test := range revision.TestCases {
+		fmt.Fprintf(tests, "%d:%s%d:%s%t;", len(test.Input), test.Input, len(test.Output), test.Output, test.IsSample)
+	}
+	statements := sha256.New()
+	for _, st := range revision.Statements {
+		json.NewEncoder(statements).Encode([]string{st.Language, st.Title, st.Legend, st.InputFormat, st.OutputFormat, st.Notes, st.Scoring})
+	}
+
+	snapshot["revision"] = revision.Number
+	snapshot["tests"] = len(revision.TestCases)
+	snapshot["tests_digest"] = hex.EncodeToString(tests.Sum(nil))
+	snapshot["statements"] = len(revision.Statements)
+	snapshot["statements_digest"] = hex.EncodeToString(statements.Sum(nil))
+	return snapshot
+}
+
+// AuditContest is what the audit log records of a contest.
+func AuditContest(contest *models.Contest) map[string]interface{} {
+	problemIDs := make([]uint, 0, len(contest.Problems))
+	for _, problem := range contest.Problems {
+		problemIDs = append(problemIDs, problem.ID)
+	}
+	sort.Slice(problemIDs, func(i, j int) bool { return problemIDs[i] < problemIDs[j] })
+
+	return map[string]interface{}{
+		"title":       contest.Title,
+		"description": contest.Description,
+		"start_time":  contest.StartTime,
+		"end_time":    contest.EndTime,
+		"is_public":   contest.IsPublic,
+		"policy":      contest.Policy,
+		"problem_ids": problemIDs,
+	}
+}
+
+// RecordAudit appends entry to the audit log. A failure is logged rather
+// than failing an action that has already happened.
+func RecordAudit(db *gorm.DB, entry *models.AuditLog) {
+	if err := db.Create(entry).Error; err != nil {
+		slog.Error("failed to record audit entry", "action", entry.Action, "target_type", entry.TargetType, "target_id", entry.TargetID, "error", err)
+	}
+}
+
+type AuditFilter struct {
+	ActorID    *uint
+	Action     string // exact action, or a target type such as "problem" for all of its actions
+	TargetType string
+	TargetID   *uint
+	IP         string
+	Since      *time.Time
+	Until      *time.Time
+}
+
+// Query selects the audit entries matching the filter, newest first.
+func (f AuditFilter) Query(db *gorm.DB) *gorm.DB {
+	q := db.Model(&models.AuditLog{})
+	if f.ActorID != nil {
+		q = q.Where("actor_id = ?", *f.ActorID)
+	}
+	if f.Action != "" {
+		q = q.Where("action = ? OR action LIKE ?", f.Action, f.Action+".%")
+	}
+	if f.TargetType != "" {
+		q = q.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetID != nil {
+		q = q.Where("target_id = ?", *f.TargetID)
+	}
+	if f.IP != "" {
+		q = q.Where("ip = ?", f.IP)
+	}
+	if f.Since != nil {
+		q = q.Where("created_at >= ?", *f.Since)
+	}
+	if f.Until != nil {
+		q = q.Where("created_at < ?", *f.Until)
+	}
+	return q.Order("id DESC")
+}
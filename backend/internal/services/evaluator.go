@@ -2,15 +2,25 @@ package services
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/onlinejudge/backend/internal/models"
 	"github.com/onlinejudge/backend/pkg/database"
+	"github.com/onlinejudge/backend/pkg/logging"
+	"github.com/onlinejudge/backend/pkg/metrics"
+	"github.com/onlinejudge/backend/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
 )
 
 type EvaluationResult struct {
@@ -24,40 +34,105 @@ type Evaluator struct {
 	workDir string
 }
 
-func NewEvaluator() *Evaluator {
+// NewEvaluator returns the evaluator shared by the judge queue and the
+// handlers that run code, working in a directory under the system's
+// temporary directory.
+func NewEvaluator() (*Evaluator, error) {
 	workDir := filepath.Join(os.TempDir(), "onlinejudge")
-	os.MkdirAll(workDir, 0755)
-	return &Evaluator{workDir: workDir}
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %v", err)
+	}
+	return &Evaluator{workDir: workDir}, nil
+}
+
+// Evaluate judges a submission and stores its verdict. Its database queries
+// and log lines use ctx, which carries the logger for the submission, and
+// its compile and test runs are traced as children of the span in ctx.
+func (e *Evaluator) Evaluate(ctx context.Context, submission *models.Submission) error {
+	log := logging.FromContext(ctx)
+	language := submission.Language
+	metrics.SubmissionsInProgress.WithLabelValues(language).Inc()
+	metrics.JudgeWorkersBusy.Inc()
+	start := time.Now()
+	log.InfoContext(ctx, "judging submission", "problem_id", submission.ProblemID, "language", language)
+
+	err := e.evaluate(ctx, submission)
+
+	elapsed := time.Since(start).Seconds()
+	metrics.SubmissionsInProgress.WithLabelValues(language).Dec()
+	metrics.JudgeWorkersBusy.Dec()
+	metrics.JudgeBusySeconds.Add(elapsed)
+	metrics.EvaluationDuration.WithLabelValues(language).Observe(elapsed)
+	if err != nil {
+		metrics.JudgeErrors.Inc()
+	} else {
+		metrics.SubmissionsCompleted.WithLabelValues(language, submission.Status).Inc()
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("submission.verdict", submission.Status))
+		log.InfoContext(ctx, "judged submission", "verdict", submission.Status, "duration_ms", time.Since(start).Milliseconds())
+	}
+	return err
 }
 
-func (e *Evaluator) Evaluate(submission *models.Submission) error {
+func (e *Evaluator) evaluate(ctx context.Context, submission *models.Submission) error {
+	if submission.Language == models.LanguageOutputOnly {
+		return e.evaluateOutputs(ctx, submission)
+	}
+
 	// Create a unique directory for this submission
 	submissionDir := filepath.Join(e.workDir, fmt.Sprintf("submission_%d", submission.ID))
 	os.MkdirAll(submissionDir, 0755)
 	defer os.RemoveAll(submissionDir)
 
+	// Get the revision to judge against, pinning it on first evaluation
+	revision, err := e.loadRevision(ctx, submission)
+	if err != nil {
+		return fmt.Errorf("failed to load problem revision: %v", err)
+	}
+
+	// Function-style problems wrap the code in the revision's harness
+	code, err := WrapSource(database.DB.WithContext(ctx), revision, submission.Language, submission.Code)
+	if err == ErrNoHarness {
+		submission.Status = "compilation_error"
+		submission.Error = err.Error()
+		return e.finish(ctx, submission)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to prepare source: %v", err)
+	}
+
 	// Write code to file
-	codeFile := filepath.Join(submissionDir, getSourceFileName(submission.Language))
-	if err := os.WriteFile(codeFile, []byte(submission.Code), 0644); err != nil {
-		return fmt.Errorf("failed to write code file: %v", err)
+	codeFile, err := writeSource(submissionDir, submission.Language, code, submission.Files)
+	if err != nil {
+		return err
 	}
 
 	// Compile if needed
-	if err := e.compile(submission.Language, codeFile); err != nil {
+	_, span := tracing.Tracer().Start(ctx, "compile", trace.WithAttributes(attribute.String("submission.language", submission.Language)))
+	err = e.compile(submission.Language, codeFile)
+	if err != nil {
+		span.SetStatus(codes.Error, "compilation failed")
+	}
+	span.End()
+	if err != nil {
 		submission.Status = "compilation_error"
 		submission.Error = err.Error()
-		database.DB.Save(submission)
-		return nil
+		return e.finish(ctx, submission)
 	}
 
-	// Get test cases
-	var testCases []models.TestCase
-	database.DB.Where("problem_id = ?", submission.ProblemID).Find(&testCases)
-
 	// Run test cases
 	var results []models.SubmissionResult
-	for _, tc := range testCases {
-		result := e.runTestCase(submission, tc, codeFile)
+	for i, tc := range revision.TestCases {
+		_, span := tracing.Tracer().Start(ctx, "run test", trace.WithAttributes(
+			attribute.Int("test.number", i+1),
+			attribute.Int("test.id", int(tc.ID)),
+		))
+		result := e.runTestCase(submission.Language, revision, tc, codeFile)
+		span.SetAttributes(
+			attribute.String("test.status", result.Status),
+			attribute.Int("test.time_ms", result.TimeUsed),
+			attribute.Int("test.memory_kb", result.MemoryUsed),
+		)
+		span.End()
 		results = append(results, models.SubmissionResult{
 			SubmissionID: submission.ID,
 			TestCaseID:   tc.ID,
@@ -73,109 +148,389 @@ func (e *Evaluator) Evaluate(submission *models.Submission) error {
 		}
 	}
 
+	return e.complete(ctx, submission, results)
+}
+
+// complete stores per-test results and takes the submission's verdict from
+// the last one. A revision without tests cannot be judged at all.
+func (e *Evaluator) complete(ctx context.Context, submission *models.Submission, results []models.SubmissionResult) error {
+	if len(results) == 0 {
+		submission.Status = "judge_error"
+		submission.Error = "problem has no tests"
+		return e.finish(ctx, submission)
+	}
+
 	// Save results
 	for _, result := range results {
-		database.DB.Create(&result)
+		database.DB.WithContext(ctx).Create(&result)
 	}
 
 	// Update submission status
 	submission.Status = results[len(results)-1].Status
 	submission.TimeUsed = results[len(results)-1].TimeUsed
 	submission.MemoryUsed = results[len(results)-1].MemoryUsed
-	database.DB.Save(submission)
 
-	return nil
+	return e.finish(ctx, submission)
+}
+
+// evaluateOutputs judges an output-only submission, whose code is a
+// base64-encoded zip holding the answer to test n as "<n>.out".
+func (e *Evaluator) evaluateOutputs(ctx context.Context, submission *models.Submission) error {
+	revision, err := e.loadRevision(ctx, submission)
+	if err != nil {
+		return fmt.Errorf("failed to load problem revision: %v", err)
+	}
+
+	archive, err := base64.StdEncoding.DecodeString(submission.Code)
+	if err != nil {
+		submission.Status = "compilation_error"
+		submission.Error = "invalid answer archive encoding"
+		return e.finish(ctx, submission)
+	}
+	outputs, err := ReadOutputArchive(archive)
+	if err != nil {
+		submission.Status = "compilation_error"
+		submission.Error = err.Error()
+		return e.finish(ctx, submission)
+	}
+
+	var results []models.SubmissionResult
+	for i, tc := range revision.TestCases {
+		result := models.SubmissionResult{
+			SubmissionID: submission.ID,
+			TestCaseID:   tc.ID,
+			Status:       "accepted",
+		}
+		name := fmt.Sprintf("%d.out", i+1)
+		if output, ok := outputs[name]; !ok {
+			result.Status = "wrong_answer"
+			result.Error = "Missing " + name
+		} else if !checkOutput(revision.Checker, output, tc.Output) {
+			result.Status = "wrong_answer"
+			result.Error = "Output does not match expected output"
+		}
+		results = append(results, result)
+
+		if result.Status != "accepted" {
+			break
+		}
+	}
+
+	return e.complete(ctx, submission, results)
+}
+
+// finish stores the final verdict and folds it into the problem statistics.
+func (e *Evaluator) finish(ctx context.Context, submission *models.Submission) error {
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(submission).Error; err != nil {
+			return err
+		}
+		return RecordVerdict(tx, submission)
+	})
+	InvalidateProfile(submission.UserID)
+	return err
+}
+
+// loadRevision returns the revision recorded on the submission, or the
+// problem's current revision when none has been recorded yet.
+func (e *Evaluator) loadRevision(ctx context.Context, submission *models.Submission) (*models.ProblemRevision, error) {
+	db := database.DB.WithContext(ctx)
+	if submission.RevisionID == 0 {
+		var problem models.Problem
+		if err := db.First(&problem, submission.ProblemID).Error; err != nil {
+			return nil, err
+		}
+		revision, err := CurrentRevision(db, &problem)
+		if err != nil {
+			return nil, err
+		}
+		submission.RevisionID = revision.ID
+		return revision, nil
+	}
+
+	var revision models.ProblemRevision
+	if err := db.Preload("TestCases", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).First(&revision, submission.RevisionID).Error; err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// SupportedLanguages lists the languages compile and runCommand know about.
+var SupportedLanguages = map[string]bool{
+	"cpp":    true,
+	"java":   true,
+	"python": true,
 }
 
 func (e *Evaluator) compile(language, codeFile string) error {
+	var cmd *exec.Cmd
 	switch language {
 	case "cpp":
-		cmd := exec.Command("g++", "-std=c++17", "-O2", codeFile, "-o", codeFile+".out")
-		return cmd.Run()
+		// Every source in the directory is compiled, for multi-file submissions
+		dir := filepath.Dir(codeFile)
+		sources, err := sourcesIn(dir, ".cpp", ".cc")
+		if err != nil {
+			return err
+		}
+		cmd = exec.Command("g++", append([]string{"-std=c++17", "-O2", "-I", dir, "-o", codeFile + ".out"}, sources...)...)
 	case "java":
-		cmd := exec.Command("javac", codeFile)
-		return cmd.Run()
+		dir := filepath.Dir(codeFile)
+		sources, err := sourcesIn(dir, ".java")
+		if err != nil {
+			return err
+		}
+		cmd = exec.Command("javac", append([]string{"-d", dir}, sources...)...)
 	case "python":
 		// Python is interpreted, no compilation needed
 		return nil
 	default:
 		return fmt.Errorf("unsupported language: %s", language)
 	}
+
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	metrics.CompileDuration.WithLabelValues(language, result).Observe(time.Since(start).Seconds())
+
+	// Keep the compiler diagnostics so users can see why compilation failed
+	if err != nil {
+		return fmt.Errorf("%v\n%s", err, output)
+	}
+	return nil
+}
+
+func (e *Evaluator) runTestCase(language string, revision *models.ProblemRevision, tc models.TestCase, codeFile string) EvaluationResult {
+	cmd := runCommand(language, codeFile)
+	if cmd == nil {
+		return EvaluationResult{Status: "runtime_error", Error: "Unsupported language"}
+	}
+
+	run := e.runWithIO(cmd, revision, tc)
+	switch {
+	case run.StartError != nil:
+		return EvaluationResult{Status: "runtime_error", Error: run.StartError.Error()}
+	case run.TimedOut:
+		return EvaluationResult{
+			Status:   "time_limit",
+			TimeUsed: revision.TimeLimit,
+			Error:    "Time limit exceeded",
+		}
+	case revision.MemoryLimit > 0 && run.MemoryUsed > revision.MemoryLimit<<10:
+		return EvaluationResult{
+			Status:     "memory_limit",
+			TimeUsed:   run.TimeUsed,
+			MemoryUsed: run.MemoryUsed,
+			Error:      "Memory limit exceeded",
+		}
+	case run.OutputLimitExceeded:
+		return EvaluationResult{
+			Status:   "output_limit",
+			TimeUsed: run.TimeUsed,
+			Error:    "Output limit exceeded",
+		}
+	case run.ExitCode != 0:
+		return EvaluationResult{
+			Status:   "runtime_error",
+			TimeUsed: run.TimeUsed,
+			Error:    run.Stderr,
+		}
+	}
+
+	// Check output
+	if !checkOutput(revision.Checker, run.Stdout, tc.Output) {
+		return EvaluationResult{
+			Status:   "wrong_answer",
+			TimeUsed: run.TimeUsed,
+			Error:    "Output does not match expected output",
+		}
+	}
+
+	return EvaluationResult{
+		Status:   "accepted",
+		TimeUsed: run.TimeUsed,
+	}
 }
 
-func (e *Evaluator) runTestCase(submission *models.Submission, tc models.TestCase, codeFile string) EvaluationResult {
+// maxProgramOutput bounds what is kept of each of stdout and stderr when
+// judging or running authoring programs. Programs writing more are killed.
+const maxProgramOutput = 64 << 20
+
+// RunLimits bounds a single execution of a program.
+type RunLimits struct {
+	Time   time.Duration
+	Memory int // in MB, unlimited when 0
+	Output int // in bytes, for stdout and stderr each
+}
+
+// ProgramRun is the raw outcome of executing a program once in the sandbox.
+type ProgramRun struct {
+	Stdout              string
+	Stderr              string
+	ExitCode            int
+	TimeUsed            int // in milliseconds
+	MemoryUsed          int // peak resident set size in KB
+	TimedOut            bool
+	OutputLimitExceeded bool
+	StartError          error
+}
+
+// runCommand builds the command that runs a compiled source file, or nil
+// if the language is not supported.
+func runCommand(language, codeFile string, args ...string) *exec.Cmd {
 	var cmd *exec.Cmd
-	switch submission.Language {
+	switch language {
 	case "cpp":
-		cmd = exec.Command(codeFile + ".out")
+		cmd = exec.Command(codeFile+".out", args...)
 	case "java":
-		cmd = exec.Command("java", "-cp", filepath.Dir(codeFile), filepath.Base(codeFile[:len(codeFile)-5]))
+		cmd = exec.Command("java", append([]string{"-cp", filepath.Dir(codeFile), filepath.Base(codeFile[:len(codeFile)-5])}, args...)...)
 	case "python":
-		cmd = exec.Command("python", codeFile)
+		cmd = exec.Command("python", append([]string{codeFile}, args...)...)
 	default:
-		return EvaluationResult{Status: "runtime_error", Error: "Unsupported language"}
+		return nil
+	}
+	cmd.Dir = filepath.Dir(codeFile)
+	return cmd
+}
+
+// limitedBuffer keeps the first limit bytes written to it and calls
+// exceeded once when more arrive, so that a program printing without end
+// is killed instead of filling the API's memory.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	over     bool
+	exceeded func()
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		if !b.over {
+			b.over = true
+			b.exceeded()
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// limitMemory caps the address space of cmd at mb through the shell's
+// ulimit, as os/exec cannot set resource limits of the child itself. The
+// JVM reserves far more address space than it uses, so Java programs get
+// a heap limit instead.
+func limitMemory(cmd *exec.Cmd, mb int) {
+	if mb <= 0 {
+		return
+	}
+	if filepath.Base(cmd.Path) == "java" {
+		cmd.Args = append([]string{cmd.Args[0], fmt.Sprintf("-Xmx%dm", mb)}, cmd.Args[1:]...)
+		return
+	}
+
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return
+	}
+	script := fmt.Sprintf(`ulimit -v %d && exec "$0" "$@"`, mb<<10)
+	cmd.Args = append([]string{"sh", "-c", script, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = sh
+}
+
+// execute runs cmd with stdin in its own process group under limits,
+// killing the whole group if it runs too long or writes too much.
+func (e *Evaluator) execute(cmd *exec.Cmd, stdin string, limits RunLimits) ProgramRun {
+	kill := func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
 	}
 
 	// Set up input/output
-	cmd.Stdin = bytes.NewReader([]byte(tc.Input))
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	cmd.Stdin = bytes.NewReader([]byte(stdin))
+	stdout := &limitedBuffer{limit: limits.Output, exceeded: kill}
+	stderr := &limitedBuffer{limit: limits.Output, exceeded: kill}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
 	// Set resource limits
+	limitMemory(cmd, limits.Memory)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
 
 	// Start the process
 	startTime := time.Now()
-	err := cmd.Start()
-	if err != nil {
-		return EvaluationResult{Status: "runtime_error", Error: err.Error()}
+	if err := cmd.Start(); err != nil {
+		return ProgramRun{StartError: err}
 	}
 
 	// Create a channel to receive the result
-	done := make(chan error)
+	done := make(chan error, 1)
 	go func() {
 		done <- cmd.Wait()
 	}()
 
-	// Set timeout
-	timeout := time.Duration(submission.Problem.TimeLimit) * time.Millisecond
 	select {
 	case err := <-done:
-		timeUsed := int(time.Since(startTime).Milliseconds())
-		if err != nil {
-			return EvaluationResult{
-				Status:   "runtime_error",
-				TimeUsed: timeUsed,
-				Error:    stderr.String(),
+		run := ProgramRun{
+			Stdout:              stdout.buf.String(),
+			Stderr:              stderr.buf.String(),
+			TimeUsed:            int(time.Since(startTime).Milliseconds()),
+			OutputLimitExceeded: stdout.over || stderr.over,
+		}
+		if cmd.ProcessState != nil {
+			if usage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+				run.MemoryUsed = int(usage.Maxrss)
 			}
 		}
-
-		// Check output
-		output := stdout.String()
-		if output != tc.Output {
-			return EvaluationResult{
-				Status:   "wrong_answer",
-				TimeUsed: timeUsed,
-				Error:    "Output does not match expected output",
+		if err != nil {
+			run.ExitCode = -1
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				run.ExitCode = exitErr.ExitCode()
 			}
 		}
+		return run
 
-		return EvaluationResult{
-			Status:   "accepted",
-			TimeUsed: timeUsed,
+	case <-time.After(limits.Time):
+		// Kill the process group
+		kill()
+		<-done
+		return ProgramRun{
+			Stdout:   stdout.buf.String(),
+			Stderr:   stderr.buf.String(),
+			TimeUsed: int(limits.Time.Milliseconds()),
+			TimedOut: true,
 		}
+	}
+}
 
-	case <-time.After(timeout):
-		// Kill the process group
-		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
-		return EvaluationResult{
-			Status:   "time_limit",
-			TimeUsed: submission.Problem.TimeLimit,
-			Error:    "Time limit exceeded",
+// SupportedCheckers lists the output comparison modes a problem can use.
+var SupportedCheckers = map[string]bool{
+	"":       true,
+	"exact":  true,
+	"tokens": true,
+}
+
+// checkOutput compares program output with the expected answer. The
+// "tokens" checker ignores differences in whitespace between tokens.
+func checkOutput(checker, output, expected string) bool {
+	switch checker {
+	case "tokens":
+		got, want := strings.Fields(output), strings.Fields(expected)
+		if len(got) != len(want) {
+			return false
 		}
+		for i := range got {
+			if got[i] != want[i] {
+				return false
+			}
+		}
+		return true
+	default:
+		return output == expected
 	}
 }
 
@@ -190,4 +545,4 @@ func getSourceFileName(language string) string {
 	default:
 		return "main"
 	}
-} 
\ No newline at end of file
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Limits on multi-file submissions. The files travel inside the NATS
+// message, whose payload is capped at 1 MB by default.
+const (
+	maxSourceFiles = 64
+	maxSourceSize  = 512 << 10 // total, in bytes
+)
+
+// sourceExtensions lists the file types each language accepts in a
+// multi-file submission.
+var sourceExtensions = map[string][]string{
+	"cpp":    {".cpp", ".cc", ".h", ".hpp"},
+	"java":   {".java"},
+	"python": {".py"},
+}
+
+// ReadSourceArchive extracts the files of a zip archive submission. A single
+// top-level directory wrapping everything is stripped.
+func ReadSourceArchive(data []byte) (map[string]string, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %v", err)
+	}
+
+	files := make(map[string]string)
+	var total int64
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if len(files) >= maxSourceFiles {
+			return nil, fmt.Errorf("submission has more than %d files", maxSourceFiles)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", f.Name, err)
+		}
+		content, err := io.ReadAll(io.LimitReader(rc, maxSourceSize-total+1))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", f.Name, err)
+		}
+		total += int64(len(content))
+		if total > maxSourceSize {
+			return nil, fmt.Errorf("submission exceeds %d KB", maxSourceSize>>10)
+		}
+		files[f.Name] = string(content)
+	}
+
+	return stripCommonDir(files), nil
+}
+
+// stripCommonDir removes a top-level directory shared by every file, as
+// archives created by zipping a project folder have one.
+func stripCommonDir(files map[string]string) map[string]string {
+	var root string
+	for name := range files {
+		dir, _, found := strings.Cut(name, "/")
+		if !found || (root != "" && dir != root) {
+			return files
+		}
+		root = dir
+	}
+
+	stripped := make(map[string]string, len(files))
+	for name, content := range files {
+		stripped[strings.TrimPrefix(name, root+"/")] = content
+	}
+	return stripped
+}
+
+// ValidateSourceFiles checks a multi-file submission against the limits and
+// the entry point rules of its language: C++ needs at least one source file
+// and compiles all of them together, Java runs the class Main from a
+// top-level Main.java and Python runs a top-level main.py.
+func ValidateSourceFiles(language string, files map[string]string) error {
+	extensions, ok := sourceExtensions[language]
+	if !ok {
+		return fmt.Errorf("unsupported language: %s", language)
+	}
+	if len(files) == 0 {
+		return errors.New("submission has no files")
+	}
+	if len(files) > maxSourceFiles {
+		return fmt.Errorf("submission has more than %d files", maxSourceFiles)
+	}
+
+	total := 0
+	hasSource := false
+	for name, content := range files {
+		clean := path.Clean(name)
+		if name == "" || clean != name || path.IsAbs(name) || strings.HasPrefix(clean, "../") || clean == ".." {
+			return fmt.Errorf("invalid file name %q", name)
+		}
+
+		ext := path.Ext(name)
+		allowed := false
+		for _, e := range extensions {
+			if ext == e {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%s files are not allowed in %s submissions", name, language)
+		}
+		if ext == ".cpp" || ext == ".cc" {
+			hasSource = true
+		}
+
+		total += len(content)
+	}
+	if total > maxSourceSize {
+		return fmt.Errorf("submission exceeds %d KB", maxSourceSize>>10)
+	}
+
+	switch language {
+	case "cpp":
+		if !hasSource {
+			return errors.New("C++ submissions need at least one .cpp file")
+		}
+	case "java", "python":
+		entry := getSourceFileName(language)
+		if _, ok := files[entry]; !ok {
+			return fmt.Errorf("%s submissions need a top-level %s", language, entry)
+		}
+	}
+
+	return nil
+}
+
+// writeSource writes a submission into dir and returns the path the
+// language's compile and run commands are keyed on: files when given,
+// otherwise code as the language's single source file.
+func writeSource(dir, language, code string, files map[string]string) (string, error) {
+	codeFile := filepath.Join(dir, getSourceFileName(language))
+	if len(files) == 0 {
+		if err := os.WriteFile(codeFile, []byte(code), 0644); err != nil {
+			return "", fmt.Errorf("failed to write code file: %v", err)
+		}
+		return codeFile, nil
+	}
+
+	for name, content := range files {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return "", fmt.Errorf("failed to create directory for %s: %v", name, err)
+		}
+		if err := os.WriteFile(target, []byte(content), 0644); err != nil {
+			return "", fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+	return codeFile, nil
+}
+
+// sourcesIn lists the files under dir with one of the given extensions.
+func sourcesIn(dir string, extensions ...string) ([]string, error) {
+	var sources []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		for _, ext := range extensions {
+			if filepath.Ext(p) == ext {
+				sources = append(sources, p)
+			}
+		}
+		return nil
+	})
+	return sources, err
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// ErrNoHarness is returned when a function-style problem has no harness
+// for the submission's language.
+var ErrNoHarness = errors.New("language not available for this problem")
+
+// SignatureTypes lists the argument and return types a function signature
+// may use. Harnesses read and write them as one JSON value per line.
+var SignatureTypes = map[string]bool{
+	"int":      true,
+	"long":     true,
+	"double":   true,
+	"bool":     true,
+	"string":   true,
+	"int[]":    true,
+	"long[]":   true,
+	"double[]": true,
+	"string[]": true,
+	"int[][]":  true,
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateSignature checks that names are identifiers and types are known.
+func ValidateSignature(sig *models.FunctionSignature) error {
+	if !identifier.MatchString(sig.Name) {
+		return fmt.Errorf("invalid function name %q", sig.Name)
+	}
+	if !SignatureTypes[sig.Returns] {
+		return fmt.Errorf("unsupported return type %q", sig.Returns)
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range sig.Params {
+		if !identifier.MatchString(p.Name) || seen[p.Name] {
+			return fmt.Errorf("invalid or duplicate parameter name %q", p.Name)
+		}
+		seen[p.Name] = true
+		if !SignatureTypes[p.Type] {
+			return fmt.Errorf("unsupported type %q for parameter %s", p.Type, p.Name)
+		}
+	}
+
+	return nil
+}
+
+// ValidateHarness checks that a harness targets a supported language and
+// has exactly one place for the user's code.
+func ValidateHarness(h *models.ProblemHarness, sig *models.FunctionSignature) error {
+	if !SupportedLanguages[h.Language] {
+		return fmt.Errorf("unsupported harness language %q", h.Language)
+	}
+	if n := strings.Count(h.Harness, models.HarnessPlaceholder); n != 1 {
+		return fmt.Errorf("%s harness must contain %s exactly once", h.Language, models.HarnessPlaceholder)
+	}
+	if h.Template != "" && !strings.Contains(h.Template, sig.Name) {
+		return fmt.Errorf("%s template does not define %s", h.Language, sig.Name)
+	}
+	return nil
+}
+
+// WrapSource returns the program to compile for code judged against a
+// revision: code itself for ordinary problems, or code spliced into the
+// revision's harness for the language for function-style ones.
+func WrapSource(db *gorm.DB, revision *models.ProblemRevision, language, code string) (string, error) {
+	if revision.Signature == nil {
+		return code, nil
+	}
+
+	var harness models.RevisionHarness
+	if err := db.Where("revision_id = ? AND language = ?", revision.ID, language).First(&harness).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrNoHarness
+		}
+		return "", err
+	}
+
+	return harness.Wrap(code), nil
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/onlinejudge/backend/internal/models"
+)
+
+const (
+	defaultInputFile  = "input.txt"
+	defaultOutputFile = "output.txt"
+
+	// Limits on output-only answer archives
+	maxArchiveEntries = 1000
+	maxArchiveSize    = 64 << 20 // uncompressed, in bytes
+)
+
+// SupportedIOModes lists the I/O modes a problem can use.
+var SupportedIOModes = map[string]bool{
+	"":                  true,
+	models.IOStdio:      true,
+	models.IOFiles:      true,
+	models.IOOutputOnly: true,
+}
+
+var ioFileName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// ValidateIOMode checks a problem's I/O mode and, for IOFiles, that the
+// file names stay inside the working directory.
+func ValidateIOMode(mode, inputFile, outputFile string) error {
+	if !SupportedIOModes[mode] {
+		return fmt.Errorf("unsupported I/O mode %q", mode)
+	}
+	if mode != models.IOFiles {
+		if inputFile != "" || outputFile != "" {
+			return errors.New("input and output files only apply to the files I/O mode")
+		}
+		return nil
+	}
+	for _, name := range []string{inputFile, outputFile} {
+		if name != "" && !ioFileName.MatchString(name) {
+			return fmt.Errorf("invalid file name %q", name)
+		}
+	}
+	if in, out := ioFiles(inputFile, outputFile); in == out {
+		return errors.New("input and output files must differ")
+	}
+	return nil
+}
+
+// ioFiles applies the default file names.
+func ioFiles(inputFile, outputFile string) (string, string) {
+	if inputFile == "" {
+		inputFile = defaultInputFile
+	}
+	if outputFile == "" {
+		outputFile = defaultOutputFile
+	}
+	return inputFile, outputFile
+}
+
+// runWithIO runs cmd on the input of tc the way the revision's I/O mode
+// prescribes. The returned run's Stdout holds the program's answer, which
+// for IOFiles is the content of the output file.
+func (e *Evaluator) runWithIO(cmd *exec.Cmd, revision *models.ProblemRevision, tc models.TestCase) ProgramRun {
+	limits := RunLimits{
+		Time:   time.Duration(revision.TimeLimit) * time.Millisecond,
+		Memory: revision.MemoryLimit,
+		Output: maxProgramOutput,
+	}
+	if revision.IOMode != models.IOFiles {
+		return e.execute(cmd, tc.Input, limits)
+	}
+
+	inputFile, outputFile := ioFiles(revision.InputFile, revision.OutputFile)
+	inputPath := filepath.Join(cmd.Dir, inputFile)
+	outputPath := filepath.Join(cmd.Dir, outputFile)
+
+	// Never let a previous test's answer count for this one
+	os.Remove(outputPath)
+	if err := os.WriteFile(inputPath, []byte(tc.Input), 0644); err != nil {
+		return ProgramRun{StartError: fmt.Errorf("failed to write input file: %v", err)}
+	}
+	defer os.Remove(inputPath)
+
+	run := e.execute(cmd, "", limits)
+
+	// A missing output file counts as an empty answer
+	output, _ := os.ReadFile(outputPath)
+	run.Stdout = string(output)
+	return run
+}
+
+// ReadOutputArchive reads the answers of an output-only submission from a
+// zip archive, keyed by base file name so archives may use a top-level
+// directory.
+func ReadOutputArchive(data []byte) (map[string]string, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %v", err)
+	}
+	if len(reader.File) > maxArchiveEntries {
+		return nil, fmt.Errorf("archive has more than %d files", maxArchiveEntries)
+	}
+
+	outputs := make(map[string]string)
+	var total int64
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name := path.Base(f.Name)
+		if !strings.HasSuffix(name, ".out") {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", f.Name, err)
+		}
+		// Guard against archives that lie about their uncompressed size
+		content, err := io.ReadAll(io.LimitReader(rc, maxArchiveSize-total+1))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", f.Name, err)
+		}
+		total += int64(len(content))
+		if total > maxArchiveSize {
+			return nil, fmt.Errorf("archive exceeds %d MB uncompressed", maxArchiveSize>>20)
+		}
+		outputs[name] = string(content)
+	}
+
+	if len(outputs) == 0 {
+		return nil, errors.New("archive contains no .out files")
+	}
+	return outputs, nil
+}
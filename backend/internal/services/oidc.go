@@ -0,0 +1,524 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcLoginTTL = 10 * time.Minute
+
+	// Keys are fetched again for an unknown key ID at most this often, so
+	// that forged tokens cannot make us hammer the provider.
+	oidcKeysRefreshInterval = time.Minute
+)
+
+var (
+	ErrUnknownProvider   = errors.New("unknown identity provider")
+	ErrInvalidLoginState = errors.New("login expired or was already used")
+	ErrEmailUnverified   = errors.New("identity provider did not verify the email address")
+)
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// OIDCProvider is an OpenID Connect identity provider users can log in
+// with. Its endpoints and signing keys are discovered from the issuer on
+// first use.
+type OIDCProvider struct {
+	Name         string   `json:"name"`
+	DisplayName  string   `json:"display_name"`
+	Issuer       string   `json:"-"`
+	ClientID     string   `json:"-"`
+	ClientSecret string   `json:"-"`
+	RedirectURL  string   `json:"-"`
+	Scopes       []string `json:"-"`
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCIdentity is what a verified ID token says about the user.
+type OIDCIdentity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+// LoadOIDCProviders reads the providers named in OIDC_PROVIDERS, a comma
+// separated list. Each name NAME is configured by OIDC_NAME_ISSUER,
+// OIDC_NAME_CLIENT_ID, OIDC_NAME_CLIENT_SECRET and optionally
+// OIDC_NAME_DISPLAY_NAME, OIDC_NAME_REDIRECT_URL and OIDC_NAME_SCOPES.
+func LoadOIDCProviders() (map[string]*OIDCProvider, error) {
+	providers := make(map[string]*OIDCProvider)
+
+	apiURL := strings.TrimRight(os.Getenv("API_URL"), "/")
+	if apiURL == "" {
+		apiURL = "http://localhost:8080"
+	}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		env := func(key string) string {
+			prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+			return os.Getenv(prefix + key)
+		}
+
+		p := &OIDCProvider{
+			Name:         name,
+			DisplayName:  env("DISPLAY_NAME"),
+			Issuer:       strings.TrimRight(env("ISSUER"), "/"),
+			ClientID:     env("CLIENT_ID"),
+			ClientSecret: env("CLIENT_SECRET"),
+			RedirectURL:  env("REDIRECT_URL"),
+			Scopes:       strings.Fields(env("SCOPES")),
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("oidc provider %q needs an issuer and a client id", name)
+		}
+		if p.DisplayName == "" {
+			p.DisplayName = name
+		}
+		if p.RedirectURL == "" {
+			p.RedirectURL = apiURL + "/api/auth/oidc/" + name + "/callback"
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+		providers[name] = p
+	}
+
+	return providers, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %v", err)
+	}
+	if strings.TrimRight(d.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("oidc discovery returned issuer %q, expected %q", d.Issuer, p.Issuer)
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// key returns the provider's RSA signing key with key ID kid.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < oidcKeysRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %v", err)
+	}
+	p.keysFetched = time.Now()
+
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// StartOIDCLogin records a new login attempt and returns the provider URL
+// to send the browser to. returnTo is the frontend path to open afterwards.
+func StartOIDCLogin(ctx context.Context, db *gorm.DB, p *OIDCProvider, returnTo string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	db.Where("expires_at < ?", now).Delete(&models.OIDCLoginState{})
+	if err := db.Create(&models.OIDCLoginState{
+		StateHash:    hashToken(state),
+		Provider:     p.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ReturnTo:     returnTo,
+		ExpiresAt:    now.Add(oidcLoginTTL),
+	}).Error; err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// LoginRedirectURL is the frontend page a finished login is sent to, with
+// the outcome in the URL fragment so that it stays out of server logs.
+func LoginRedirectURL(fragment url.Values) string {
+	return appURL() + "/auth/callback#" + fragment.Encode()
+}
+
+// consumeLoginState looks up and deletes a login attempt, so that each
+// state value works once.
+func consumeLoginState(db *gorm.DB, provider, state string) (*models.OIDCLoginState, error) {
+	var login models.OIDCLoginState
+	if err := db.Where("state_hash = ? AND provider = ?", hashToken(state), provider).First(&login).Error; err != nil {
+		return nil, ErrInvalidLoginState
+	}
+
+	result := db.Delete(&login)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || time.Now().After(login.ExpiresAt) {
+		return nil, ErrInvalidLoginState
+	}
+	return &login, nil
+}
+
+// exchangeCode redeems an authorization code for an ID token.
+func (p *OIDCProvider) exchangeCode(ctx context.Context, code, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid token response: %v", err)
+	}
+	if body.Error != "" {
+		return "", fmt.Errorf("token request failed: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// verifyIDToken checks an ID token's signature, issuer, audience, expiry
+// and nonce.
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, raw, nonce string) (*OIDCIdentity, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The issuer must match the one from discovery exactly
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("id token has the wrong nonce")
+	}
+
+	identity := &OIDCIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.PreferredUsername, _ = claims["preferred_username"].(string)
+	// Some providers send email_verified as a string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+	if identity.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	return identity, nil
+}
+
+// FinishOIDCLogin completes a login that came back to the callback with
+// code and state and returns the user it belongs to, along with the
+// frontend path the login started from.
+func FinishOIDCLogin(ctx context.Context, db *gorm.DB, p *OIDCProvider, code, state string) (*models.User, string, error) {
+	login, err := consumeLoginState(db, p.Name, state)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rawIDToken, err := p.exchangeCode(ctx, code, login.CodeVerifier)
+	if err != nil {
+		return nil, "", err
+	}
+	identity, err := p.verifyIDToken(ctx, rawIDToken, login.Nonce)
+	if err != nil {
+		return nil, "", err
+	}
+
+	user, err := linkOIDCUser(db, p.Name, identity)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, login.ReturnTo, nil
+}
+
+// linkOIDCUser finds the user an identity belongs to. Unknown identities
+// are linked to the account with the same email address, or get a new
+// account, but only if the provider verified that address.
+func linkOIDCUser(db *gorm.DB, provider string, identity *OIDCIdentity) (*models.User, error) {
+	var link models.UserIdentity
+	err := db.Where("provider = ? AND subject = ?", provider, identity.Subject).First(&link).Error
+	if err == nil {
+		var user models.User
+		if err := db.First(&user, link.UserID).Error; err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrEmailUnverified
+	}
+
+	var user models.User
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Where("email = ?", identity.Email).First(&user).Error
+		switch {
+		case err == nil:
+			if user.EmailVerifiedAt == nil {
+				// Whoever registered the address without verifying it may
+				// not own it, so their password stops working.
+				if err := resetUnverifiedAccount(tx, &user, now); err != nil {
+					return err
+				}
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := createOIDCUser(tx, &user, identity, now); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func resetUnverifiedAccount(tx *gorm.DB, user *models.User, now time.Time) error {
+	password, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	user.Password = password
+	if err := user.HashPassword(); err != nil {
+		return err
+	}
+	user.EmailVerifiedAt = &now
+	if err := tx.Model(user).Updates(map[string]interface{}{
+		"password":          user.Password,
+		"email_verified_at": now,
+	}).Error; err != nil {
+		return err
+	}
+	return RevokeUserAccess(tx, user.ID)
+}
+
+var usernameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// createOIDCUser creates an account for a new identity. It has a random
+// password; the user can set one through password reset.
+func createOIDCUser(tx *gorm.DB, user *models.User, identity *OIDCIdentity, now time.Time) error {
+	base := identity.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+	base = usernameInvalid.ReplaceAllString(base, "")
+	if base == "" {
+		base = "user"
+	}
+	if len(base) > 32 {
+		base = base[:32]
+	}
+
+	username := base
+	for i := 2; ; i++ {
+		var count int64
+		tx.Model(&models.User{}).Where("username = ?", username).Count(&count)
+		if count == 0 {
+			break
+		}
+		if i > 100 {
+			return errors.New("could not find a free username")
+		}
+		username = fmt.Sprintf("%s%d", base, i)
+	}
+
+	password, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	*user = models.User{
+		Username:        username,
+		Email:           identity.Email,
+		Password:        password,
+		EmailVerifiedAt: &now,
+	}
+	if err := user.HashPassword(); err != nil {
+		return err
+	}
+	return tx.Create(user).Error
+}
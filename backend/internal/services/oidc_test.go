@@ -0,0 +1,310 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const testClientID = "onlinejudge"
+
+// fakeProvider is an OpenID Connect provider serving discovery, its signing
+// keys and a token endpoint that returns an ID token with the claims made
+// by claims for the nonce of the login.
+type fakeProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims func(nonce string) jwt.MapClaims
+	nonce  string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                f.server.URL,
+			AuthorizationEndpoint: f.server.URL + "/authorize",
+			TokenEndpoint:         f.server.URL + "/token",
+			JWKSURI:               f.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "code" || r.PostFormValue("code_verifier") == "" {
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, f.claims(f.nonce))
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeProvider) provider() *OIDCProvider {
+	return &OIDCProvider{
+		Name:        "test",
+		Issuer:      f.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: "http://localhost:8080/api/auth/oidc/test/callback",
+		Scopes:      []string{"openid", "email"},
+	}
+}
+
+// idClaims are the claims of a valid ID token for nonce.
+func (f *fakeProvider) idClaims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            f.server.URL,
+		"aud":            testClientID,
+		"sub":            "subject-1",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+}
+
+// login runs a login through the fake provider and returns its outcome.
+func (f *fakeProvider) login(t *testing.T, db *gorm.DB) (*models.User, string, error) {
+	t.Helper()
+	ctx := context.Background()
+	p := f.provider()
+
+	redirect, err := StartOIDCLogin(ctx, db, p, "/problems/1")
+	if err != nil {
+		t.Fatalf("StartOIDCLogin() = %v", err)
+	}
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(redirect, f.server.URL+"/authorize?") {
+		t.Fatalf("redirected to %s", redirect)
+	}
+	f.nonce = u.Query().Get("nonce")
+
+	return FinishOIDCLogin(ctx, db, p, "code", u.Query().Get("state"))
+}
+
+func newOIDCTestDB(t *testing.T) *gorm.DB {
+	return newTestDB(t, &models.User{}, &models.UserIdentity{}, &models.OIDCLoginState{},
+		&models.Session{}, &models.APIToken{})
+}
+
+func TestFinishOIDCLoginRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  func(f *fakeProvider, nonce string) jwt.MapClaims
+		wantErr string
+	}{
+		{
+			name: "wrong nonce",
+			claims: func(f *fakeProvider, nonce string) jwt.MapClaims {
+				c := f.idClaims(nonce)
+				c["nonce"] = "replayed"
+				return c
+			},
+			wantErr: "wrong nonce",
+		},
+		{
+			name: "wrong audience",
+			claims: func(f *fakeProvider, nonce string) jwt.MapClaims {
+				c := f.idClaims(nonce)
+				c["aud"] = "another-client"
+				return c
+			},
+			wantErr: "audience",
+		},
+		{
+			name: "wrong issuer",
+			claims: func(f *fakeProvider, nonce string) jwt.MapClaims {
+				c := f.idClaims(nonce)
+				c["iss"] = "https://evil.example.com"
+				return c
+			},
+			wantErr: "issuer",
+		},
+		{
+			name: "expired",
+			claims: func(f *fakeProvider, nonce string) jwt.MapClaims {
+				c := f.idClaims(nonce)
+				c["exp"] = time.Now().Add(-time.Hour).Unix()
+				return c
+			},
+			wantErr: "expired",
+		},
+		{
+			name: "unverified email",
+			claims: func(f *fakeProvider, nonce string) jwt.MapClaims {
+				c := f.idClaims(nonce)
+				c["email_verified"] = false
+				return c
+			},
+			wantErr: ErrEmailUnverified.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newOIDCTestDB(t)
+			f := newFakeProvider(t)
+			f.claims = func(nonce string) jwt.MapClaims { return tt.claims(f, nonce) }
+
+			user, _, err := f.login(t, db)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("FinishOIDCLogin() = %v, %v, want error containing %q", user, err, tt.wantErr)
+			}
+
+			var users, identities int64
+			db.Model(&models.User{}).Count(&users)
+			db.Model(&models.UserIdentity{}).Count(&identities)
+			if users != 0 || identities != 0 {
+				t.Errorf("rejected login left %d users and %d identities", users, identities)
+			}
+		})
+	}
+}
+
+func TestFinishOIDCLoginCreatesAccount(t *testing.T) {
+	db := newOIDCTestDB(t)
+	f := newFakeProvider(t)
+	f.claims = f.idClaims
+
+	user, returnTo, err := f.login(t, db)
+	if err != nil {
+		t.Fatalf("FinishOIDCLogin() = %v", err)
+	}
+	if returnTo != "/problems/1" {
+		t.Errorf("returnTo = %q", returnTo)
+	}
+	if user.Username != "alice" || user.Email != "alice@example.com" || user.EmailVerifiedAt == nil {
+		t.Errorf("created user = %+v", user)
+	}
+
+	// The second login finds the account through the linked identity
+	again, _, err := f.login(t, db)
+	if err != nil {
+		t.Fatalf("second FinishOIDCLogin() = %v", err)
+	}
+	if again.ID != user.ID {
+		t.Errorf("second login returned user %d, want %d", again.ID, user.ID)
+	}
+}
+
+func TestFinishOIDCLoginStateIsSingleUse(t *testing.T) {
+	db := newOIDCTestDB(t)
+	f := newFakeProvider(t)
+	f.claims = f.idClaims
+	ctx := context.Background()
+	p := f.provider()
+
+	redirect, err := StartOIDCLogin(ctx, db, p, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(redirect)
+	f.nonce = u.Query().Get("nonce")
+	state := u.Query().Get("state")
+
+	if _, _, err := FinishOIDCLogin(ctx, db, p, "code", state); err != nil {
+		t.Fatalf("FinishOIDCLogin() = %v", err)
+	}
+	if _, _, err := FinishOIDCLogin(ctx, db, p, "code", state); !errors.Is(err, ErrInvalidLoginState) {
+		t.Errorf("replayed state error = %v, want %v", err, ErrInvalidLoginState)
+	}
+}
+
+func TestFinishOIDCLoginLinksExistingAccount(t *testing.T) {
+	tests := []struct {
+		name     string
+		verified bool
+	}{
+		// Whoever registered the address without verifying it may not own
+		// it, so they lose their password and sessions
+		{"unverified", false},
+		{"verified", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newOIDCTestDB(t)
+			existing := createTestUser(t, db, "alice")
+			if tt.verified {
+				now := time.Now()
+				db.Model(existing).Update("email_verified_at", now)
+			}
+			session := models.Session{UserID: existing.ID, RefreshTokenHash: "refresh", ExpiresAt: time.Now().Add(time.Hour)}
+			if err := db.Create(&session).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			f := newFakeProvider(t)
+			f.claims = f.idClaims
+			user, _, err := f.login(t, db)
+			if err != nil {
+				t.Fatalf("FinishOIDCLogin() = %v", err)
+			}
+			if user.ID != existing.ID {
+				t.Fatalf("logged in as user %d, want the existing user %d", user.ID, existing.ID)
+			}
+
+			var identity models.UserIdentity
+			if err := db.Where("provider = ? AND subject = ?", "test", "subject-1").First(&identity).Error; err != nil || identity.UserID != existing.ID {
+				t.Errorf("identity = %+v, %v, want it linked to user %d", identity, err, existing.ID)
+			}
+
+			var stored models.User
+			db.First(&stored, existing.ID)
+			db.First(&session, session.ID)
+			passwordKept := stored.CheckPassword("secret123") == nil
+			if stored.EmailVerifiedAt == nil {
+				t.Error("email address is not verified after the login")
+			}
+			if passwordKept != tt.verified {
+				t.Errorf("password kept = %v, want %v", passwordKept, tt.verified)
+			}
+			if revoked := session.RevokedAt != nil; revoked == tt.verified {
+				t.Errorf("session revoked = %v, want %v", revoked, !tt.verified)
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Winnowing parameters: fingerprints are hashes of plagiarismK consecutive
+// tokens, and the smallest hash of every window of plagiarismWindow
+// consecutive k-grams is kept. Any match of at least
+// plagiarismK+plagiarismWindow-1 tokens is guaranteed to be detected.
+const (
+	plagiarismK      = 5
+	plagiarismWindow = 4
+)
+
+// DefaultPlagiarismThreshold is the lowest similarity a pair needs to be
+// included in a report.
+const DefaultPlagiarismThreshold = 0.5
+
+// MinPlagiarismThreshold is the lowest threshold a check accepts. Lower ones
+// would store nearly every pair of a large contest, as unrelated solutions
+// to one problem share some fingerprints.
+const MinPlagiarismThreshold = 0.2
+
+// languageKeywords are kept verbatim when tokenizing, every other
+// identifier is replaced by a placeholder so renames do not hide copies.
+var languageKeywords = map[string]map[string]bool{
+	"cpp": keywordSet("auto bool break case char class const continue default delete do double else enum " +
+		"false float for if int long namespace new nullptr private protected public return short signed " +
+		"sizeof static struct switch template this true typedef typename unsigned using void while"),
+	"java": keywordSet("abstract boolean break byte case catch char class continue default do double else " +
+		"enum extends false final finally float for if implements import instanceof int interface long new " +
+		"null private protected public return short static super switch this throw throws true try void while"),
+	"python": keywordSet("and as assert break class continue def del elif else except False finally for " +
+		"from global if import in is lambda None nonlocal not or pass raise return True try while with yield"),
+}
+
+func keywordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// token is a normalized lexical token and the line it starts on.
+type token struct {
+	text string
+	line int
+}
+
+// tokenize splits source into normalized tokens, dropping whitespace,
+// comments and C++ preprocessor lines, and replacing identifiers, numbers
+// and string literals with placeholders.
+func tokenize(language, source string) []token {
+	keywords := languageKeywords[language]
+	python := language == "python"
+
+	var tokens []token
+	runes := []rune(source)
+	line := 1
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		var next rune
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		switch {
+		case r == '\n':
+			line++
+			i++
+
+		case unicode.IsSpace(r):
+			i++
+
+		// Line comments, and preprocessor directives in C++
+		case (r == '#' && language != "java") || (r == '/' && next == '/' && !python):
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+
+		case r == '/' && next == '*' && !python:
+			i += 2
+			for i < len(runes) && !(runes[i] == '*' && i+1 < len(runes) && runes[i+1] == '/') {
+				if runes[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			i += 2
+
+		case r == '"' || r == '\'':
+			start := line
+			quote := string(r)
+			if python && next == r && i+2 < len(runes) && runes[i+2] == r {
+				quote = strings.Repeat(quote, 3)
+			}
+			i += len(quote)
+			for i < len(runes) && !strings.HasPrefix(string(runes[i:min(i+len(quote), len(runes))]), quote) {
+				if runes[i] == '\\' {
+					i++
+				}
+				if i < len(runes) && runes[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			i += len(quote)
+			tokens = append(tokens, token{text: "S", line: start})
+
+		case unicode.IsDigit(r):
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{text: "N", line: line})
+
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			word := string(runes[start:i])
+			if !keywords[word] {
+				word = "V"
+			}
+			tokens = append(tokens, token{text: word, line: line})
+
+		default:
+			tokens = append(tokens, token{text: string(r), line: line})
+			i++
+		}
+	}
+
+	return tokens
+}
+
+// fingerprints winnows the k-gram hashes of tokens, mapping each selected
+// hash to the position of the first k-gram it was selected for.
+func fingerprints(tokens []token) map[uint64]int {
+	n := len(tokens) - plagiarismK + 1
+	if n <= 0 {
+		return nil
+	}
+
+	hashes := make([]uint64, n)
+	for i := range hashes {
+		h := fnv.New64a()
+		for _, t := range tokens[i : i+plagiarismK] {
+			h.Write([]byte(t.text))
+			h.Write([]byte{0})
+		}
+		hashes[i] = h.Sum64()
+	}
+
+	prints := make(map[uint64]int)
+	window := plagiarismWindow
+	if window > n {
+		window = n
+	}
+	for start := 0; start+window <= n; start++ {
+		// Rightmost minimum, as in the original winnowing algorithm
+		best := start
+		for i := start; i < start+window; i++ {
+			if hashes[i] <= hashes[best] {
+				best = i
+			}
+		}
+		if _, ok := prints[hashes[best]]; !ok {
+			prints[hashes[best]] = best
+		}
+	}
+
+	return prints
+}
+
+// SubmissionSource returns the code of a submission as one text, with the
+// files of multi-file submissions concatenated in name order. Match line
+// numbers refer to this text.
+func SubmissionSource(s *models.Submission) string {
+	if len(s.Files) == 0 {
+		return s.Code
+	}
+
+	names := make([]string, 0, len(s.Files))
+	for name := range s.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	comment := "//"
+	if s.Language == "python" {
+		comment = "#"
+	}
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s %s\n%s\n", comment, name, s.Files[name])
+	}
+	return b.String()
+}
+
+type fingerprintedSubmission struct {
+	submission *models.Submission
+	tokens     []token
+	prints     map[uint64]int
+}
+
+// compareSubmissions returns the Dice similarity of the fingerprint sets of
+// a and b together with the matching regions.
+func compareSubmissions(a, b *fingerprintedSubmission) (float64, []models.MatchRegion) {
+	if len(a.prints) == 0 || len(b.prints) == 0 {
+		return 0, nil
+	}
+
+	var regions []models.MatchRegion
+	shared := 0
+	for h, pa := range a.prints {
+		pb, ok := b.prints[h]
+		if !ok {
+			continue
+		}
+		shared++
+		regions = append(regions, models.MatchRegion{
+			StartA: a.tokens[pa].line,
+			EndA:   a.tokens[pa+plagiarismK-1].line,
+			StartB: b.tokens[pb].line,
+			EndB:   b.tokens[pb+plagiarismK-1].line,
+		})
+	}
+
+	similarity := 2 * float64(shared) / float64(len(a.prints)+len(b.prints))
+	return similarity, mergeRegions(regions)
+}
+
+// mergeRegions joins regions that overlap or touch on both sides.
+func mergeRegions(regions []models.MatchRegion) []models.MatchRegion {
+	sort.Slice(regions, func(i, j int) bool {
+		if regions[i].StartA != regions[j].StartA {
+			return regions[i].StartA < regions[j].StartA
+		}
+		return regions[i].StartB < regions[j].StartB
+	})
+
+	var merged []models.MatchRegion
+	for _, r := range regions {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			if r.StartA <= last.EndA+1 && r.StartB <= last.EndB+1 && r.EndB >= last.StartB-1 {
+				last.EndA = max(last.EndA, r.EndA)
+				last.StartB = min(last.StartB, r.StartB)
+				last.EndB = max(last.EndB, r.EndB)
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// StartPlagiarismCheck runs a pending report on a separate goroutine.
+func StartPlagiarismCheck(db *gorm.DB, report *models.PlagiarismReport) {
+	go func(id uint) {
+		if err := RunPlagiarismCheck(db, id); err != nil {
+			slog.Error("plagiarism check failed", "report_id", id, "error", err)
+			db.Model(&models.PlagiarismReport{}).Where("id = ?", id).Updates(map[string]interface{}{
+				"status": "error",
+				"error":  err.Error(),
+			})
+		}
+	}(report.ID)
+}
+
+// RunPlagiarismCheck compares the latest accepted submission of every user
+// to each problem of the report's contest, per language, and stores the
+// pairs at or above the report's threshold.
+func RunPlagiarismCheck(db *gorm.DB, reportID uint) error {
+	var report models.PlagiarismReport
+	if err := db.First(&report, reportID).Error; err != nil {
+		return err
+	}
+
+	var submissions []models.Submission
+	if err := db.Where("contest_id = ? AND status = ? AND language <> ?", report.ContestID, "accepted", models.LanguageOutputOnly).
+		Order("id DESC").Find(&submissions).Error; err != nil {
+		return err
+	}
+
+	// Keep the latest accepted submission per user, problem and language
+	type groupKey struct {
+		problemID uint
+		language  string
+	}
+	groups := make(map[groupKey][]*fingerprintedSubmission)
+	seen := make(map[string]bool)
+	for i := range submissions {
+		s := &submissions[i]
+		key := fmt.Sprintf("%d/%d/%s", s.UserID, s.ProblemID, s.Language)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		tokens := tokenize(s.Language, SubmissionSource(s))
+		group := groupKey{problemID: s.ProblemID, language: s.Language}
+		groups[group] = append(groups[group], &fingerprintedSubmission{
+			submission: s,
+			tokens:     tokens,
+			prints:     fingerprints(tokens),
+		})
+	}
+
+	var pairs []models.PlagiarismPair
+	for key, docs := range groups {
+		for i := 0; i < len(docs); i++ {
+			for j := i + 1; j < len(docs); j++ {
+				similarity, matches := compareSubmissions(docs[i], docs[j])
+				if similarity < report.Threshold {
+					continue
+				}
+				a, b := docs[i].submission, docs[j].submission
+				if a.ID > b.ID {
+					a, b = b, a
+					for k := range matches {
+						m := &matches[k]
+						m.StartA, m.StartB = m.StartB, m.StartA
+						m.EndA, m.EndB = m.EndB, m.EndA
+					}
+				}
+				pairs = append(pairs, models.PlagiarismPair{
+					ReportID:    report.ID,
+					ProblemID:   key.problemID,
+					Language:    key.language,
+					SubmissionA: a.ID,
+					SubmissionB: b.ID,
+					UserA:       a.UserID,
+					UserB:       b.UserID,
+					Similarity:  similarity,
+					Matches:     matches,
+				})
+			}
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if len(pairs) > 0 {
+			if err := tx.CreateInBatches(&pairs, 100).Error; err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		return tx.Model(&report).Updates(map[string]interface{}{
+			"status":      "done",
+			"finished_at": &now,
+		}).Error
+	})
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/onlinejudge/backend/internal/models"
+)
+
+func fingerprinted(language, source string) *fingerprintedSubmission {
+	tokens := tokenize(language, source)
+	return &fingerprintedSubmission{tokens: tokens, prints: fingerprints(tokens)}
+}
+
+const sumSource = `#include <iostream>
+using namespace std;
+
+int main() {
+    int n;
+    cin >> n;
+    long long total = 0;
+    for (int i = 0; i < n; i++) {
+        int x;
+        cin >> x;
+        total += x;
+    }
+    cout << total << endl;
+    return 0;
+}
+`
+
+func TestCompareSubmissionsSimilarity(t *testing.T) {
+	tests := []struct {
+		name     string
+		other    string
+		min, max float64
+	}{
+		{
+			name:  "identical",
+			other: sumSource,
+			min:   1, max: 1,
+		},
+		{
+			name: "renamed and reformatted",
+			other: `#include <bits/stdc++.h>
+using namespace std;
+// sums the input
+int main()
+{
+  int count; cin >> count;
+  long long acc = 0; /* running sum */
+  for (int j = 0; j < count; j++)
+  {
+    int value;
+    cin >> value;
+    acc += value;
+  }
+  cout << acc << endl;
+  return 0;
+}
+`,
+			min: 1, max: 1,
+		},
+		{
+			name: "unrelated",
+			other: `#include <vector>
+#include <algorithm>
+
+struct Edge { int to, weight; };
+
+bool better(const Edge& a, const Edge& b) {
+    if (a.weight != b.weight) return a.weight < b.weight;
+    return a.to > b.to;
+}
+
+void sortEdges(std::vector<Edge>& edges) {
+    std::sort(edges.begin(), edges.end(), better);
+    while (!edges.empty() && edges.back().weight == 0) edges.pop_back();
+}
+`,
+			min: 0, max: 0.2,
+		},
+	}
+
+	a := fingerprinted("cpp", sumSource)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			similarity, _ := compareSubmissions(a, fingerprinted("cpp", tt.other))
+			if similarity < tt.min || similarity > tt.max {
+				t.Errorf("similarity = %.2f, want between %.2f and %.2f", similarity, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestTokenizeLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		source   string
+		want     []token
+	}{
+		{
+			name:     "block comment",
+			language: "cpp",
+			source:   "a = 1;\n/* one\ntwo */\nb = 2;",
+			want: []token{
+				{"V", 1}, {"=", 1}, {"N", 1}, {";", 1},
+				{"V", 4}, {"=", 4}, {"N", 4}, {";", 4},
+			},
+		},
+		{
+			name:     "line comment and preprocessor",
+			language: "cpp",
+			source:   "#include <x>\nint a; // a = \"b\"\nreturn a;",
+			want: []token{
+				{"int", 2}, {"V", 2}, {";", 2},
+				{"return", 3}, {"V", 3}, {";", 3},
+			},
+		},
+		{
+			name:     "strings",
+			language: "java",
+			source:   "s = \"a\\\"b // c\";\nt = 'x';",
+			want: []token{
+				{"V", 1}, {"=", 1}, {"S", 1}, {";", 1},
+				{"V", 2}, {"=", 2}, {"S", 2}, {";", 2},
+			},
+		},
+		{
+			name:     "python triple quotes",
+			language: "python",
+			source:   "def f():\n    \"\"\"Doc\n    with \"quotes\" inside\n    \"\"\"\n    return 1 # done",
+			want: []token{
+				{"def", 1}, {"V", 1}, {"(", 1}, {")", 1}, {":", 1},
+				{"S", 2},
+				{"return", 5}, {"N", 5},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenize(tt.language, tt.source); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareSubmissionsRegions(t *testing.T) {
+	// The copy renames the function and puts three comment lines and a
+	// two-line string before the same code, so it starts five lines later
+	original := `def solve(values):
+    best = 0
+    for v in values:
+        if v > best:
+            best = v
+    return best
+`
+	copied := `# copied
+# from a friend
+# do not tell
+NOTE = """Largest
+element"""
+def answer(xs):
+    best = 0
+    for v in xs:
+        if v > best:
+            best = v
+    return best
+`
+	similarity, regions := compareSubmissions(fingerprinted("python", original), fingerprinted("python", copied))
+	if similarity < 0.8 {
+		t.Errorf("similarity = %.2f, want at least 0.8", similarity)
+	}
+	if len(regions) != 1 {
+		t.Fatalf("regions = %+v, want one", regions)
+	}
+	r := regions[0]
+	// Winnowing need not select the last k-gram, so the region may end a
+	// line early, but both sides must cover the same code
+	if r.StartA != 1 || r.EndA < 5 || r.StartB != r.StartA+5 || r.EndB != r.EndA+5 {
+		t.Errorf("region = %+v, want the original from line 1 matched five lines later in the copy", r)
+	}
+}
+
+func TestMergeRegions(t *testing.T) {
+	tests := []struct {
+		name    string
+		regions []models.MatchRegion
+		want    []models.MatchRegion
+	}{
+		{
+			name: "overlapping",
+			regions: []models.MatchRegion{
+				{StartA: 5, EndA: 8, StartB: 15, EndB: 18},
+				{StartA: 1, EndA: 4, StartB: 11, EndB: 14},
+				{StartA: 3, EndA: 6, StartB: 13, EndB: 16},
+			},
+			want: []models.MatchRegion{{StartA: 1, EndA: 8, StartB: 11, EndB: 18}},
+		},
+		{
+			name: "apart in one submission",
+			regions: []models.MatchRegion{
+				{StartA: 1, EndA: 3, StartB: 1, EndB: 3},
+				{StartA: 4, EndA: 6, StartB: 20, EndB: 22},
+			},
+			want: []models.MatchRegion{
+				{StartA: 1, EndA: 3, StartB: 1, EndB: 3},
+				{StartA: 4, EndA: 6, StartB: 20, EndB: 22},
+			},
+		},
+		{
+			name: "apart in both",
+			regions: []models.MatchRegion{
+				{StartA: 10, EndA: 12, StartB: 10, EndB: 12},
+				{StartA: 1, EndA: 2, StartB: 1, EndB: 2},
+			},
+			want: []models.MatchRegion{
+				{StartA: 1, EndA: 2, StartB: 1, EndB: 2},
+				{StartA: 10, EndA: 12, StartB: 10, EndB: 12},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeRegions(tt.regions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeRegions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// DefaultMaxSourceSize limits single-file submissions when no policy sets a
+// smaller limit. Multi-file submissions may use up to maxSourceSize.
+const DefaultMaxSourceSize = 64 << 10
+
+// Policy violation codes, returned to clients with the error message.
+const (
+	PolicyLanguage    = "language_not_allowed"
+	PolicySourceSize  = "source_too_large"
+	PolicyCooldown    = "cooldown"
+	PolicySubmissions = "submission_limit"
+)
+
+// PolicyViolation is returned when a submission breaks a policy.
+type PolicyViolation struct {
+	Code       string
+	Message    string
+	RetryAfter time.Duration // PolicyCooldown only
+}
+
+func (v *PolicyViolation) Error() string {
+	return v.Message
+}
+
+// ValidatePolicy checks a policy an author sets on a problem or contest.
+func ValidatePolicy(policy *models.SubmissionPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	for _, lang := range policy.Languages {
+		if !SupportedLanguages[lang] {
+			return fmt.Errorf("unsupported language in policy: %s", lang)
+		}
+	}
+	if policy.MaxSourceSize < 0 || policy.MinInterval < 0 || policy.MaxSubmissions < 0 {
+		return fmt.Errorf("policy limits cannot be negative")
+	}
+	return nil
+}
+
+// submissionSize is the size of a submission's code and files.
+func submissionSize(submission *models.Submission) int {
+	size := len(submission.Code)
+	for _, content := range submission.Files {
+		size += len(content)
+	}
+	return size
+}
+
+func languageAllowed(policy *models.SubmissionPolicy, language string) bool {
+	if policy == nil || len(policy.Languages) == 0 {
+		return true
+	}
+	for _, lang := range policy.Languages {
+		if lang == language {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveContest returns the running contest that includes the problem and
+// that the user is registered in, or nil when there is none. Submissions
+// that leave out contest_id are attributed to it, so its policy cannot be
+// dodged by omitting the ID.
+func ResolveContest(db *gorm.DB, userID, problemID uint) (*models.Contest, error) {
+	now := time.Now()
+	var contests []models.Contest
+	if err := db.Joins("JOIN contest_problems ON contest_problems.contest_id = contests.id").
+		Joins("JOIN contest_users ON contest_users.contest_id = contests.id").
+		Where("contest_problems.problem_id = ? AND contest_users.user_id = ?", problemID, userID).
+		Where("contests.start_time <= ? AND contests.end_time > ?", now, now).
+		Order("contests.start_time DESC").
+		Limit(1).
+		Find(&contests).Error; err != nil {
+		return nil, err
+	}
+	if len(contests) == 0 {
+		return nil, nil
+	}
+	return &contests[0], nil
+}
+
+// CheckSubmissionPolicy validates a new submission against the problem's
+// policy and, for contest submissions, the contest's. Both apply: the
+// stricter limit wins and a language must be allowed by each. Sample runs
+// are not counted as submissions, so counted is false for them and only
+// the language and size are checked. Counted submissions must be checked
+// and stored in one transaction holding the user's row lock, or concurrent
+// submissions could each pass the cooldown and submission limit.
+func CheckSubmissionPolicy(db *gorm.DB, problem *models.Problem, contest *models.Contest, submission *models.Submission, counted bool) error {
+	outputOnly := submission.Language == models.LanguageOutputOnly
+	if !outputOnly && !SupportedLanguages[submission.Language] {
+		langs := make([]string, 0, len(SupportedLanguages))
+		for lang := range SupportedLanguages {
+			langs = append(langs, lang)
+		}
+		sort.Strings(langs)
+		return &PolicyViolation{
+			Code:    PolicyLanguage,
+			Message: fmt.Sprintf("unsupported language %q, expected one of %s", submission.Language, strings.Join(langs, ", ")),
+		}
+	}
+
+	policies := []*models.SubmissionPolicy{problem.Policy}
+	if contest != nil {
+		policies = append(policies, contest.Policy)
+	}
+
+	// Multi-file submissions have their own, larger cap; a policy can only
+	// lower either
+	maxSize := DefaultMaxSourceSize
+	if len(submission.Files) > 0 {
+		maxSize = maxSourceSize
+	}
+	for _, policy := range policies {
+		if !outputOnly && !languageAllowed(policy, submission.Language) {
+			return &PolicyViolation{
+				Code:    PolicyLanguage,
+				Message: fmt.Sprintf("language %s is not allowed here, use one of %s", submission.Language, strings.Join(policy.Languages, ", ")),
+			}
+		}
+		if policy != nil && policy.MaxSourceSize > 0 && policy.MaxSourceSize < maxSize {
+			maxSize = policy.MaxSourceSize
+		}
+	}
+	// Answer archives are limited by SubmitOutputs
+	if size := submissionSize(submission); !outputOnly && size > maxSize {
+		return &PolicyViolation{
+			Code:    PolicySourceSize,
+			Message: fmt.Sprintf("source is %d bytes, the limit is %d bytes", size, maxSize),
+		}
+	}
+
+	if !counted {
+		return nil
+	}
+
+	// The problem's limits count every submission to it; the contest's
+	// count submissions made in the contest
+	if err := checkSubmissionLimits(db, problem.Policy, submission, nil); err != nil {
+		return err
+	}
+	if contest != nil {
+		return checkSubmissionLimits(db, contest.Policy, submission, &contest.ID)
+	}
+	return nil
+}
+
+func checkSubmissionLimits(db *gorm.DB, policy *models.SubmissionPolicy, submission *models.Submission, contestID *uint) error {
+	if policy == nil {
+		return nil
+	}
+
+	scope := func() *gorm.DB {
+		q := db.Model(&models.Submission{}).Where("user_id = ?", submission.UserID)
+		if contestID != nil {
+			return q.Where("contest_id = ?", *contestID)
+		}
+		return q.Where("problem_id = ?", submission.ProblemID)
+	}
+
+	if policy.MinInterval > 0 {
+		var last models.Submission
+		if err := scope().Order("created_at DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		interval := time.Duration(policy.MinInterval) * time.Second
+		if last.ID != 0 {
+			if wait := interval - time.Since(last.CreatedAt); wait > 0 {
+				return &PolicyViolation{
+					Code:       PolicyCooldown,
+					Message:    fmt.Sprintf("wait %d seconds between submissions", policy.MinInterval),
+					RetryAfter: wait,
+				}
+			}
+		}
+	}
+
+	if policy.MaxSubmissions > 0 {
+		var count int64
+		if err := scope().Where("problem_id = ?", submission.ProblemID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= int64(policy.MaxSubmissions) {
+			return &PolicyViolation{
+				Code:    PolicySubmissions,
+				Message: fmt.Sprintf("you have used all %d submissions to this problem", policy.MaxSubmissions),
+			}
+		}
+	}
+
+	return nil
+}
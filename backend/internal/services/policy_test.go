@@ -0,0 +1,216 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm/clause"
+)
+
+func TestCheckSubmissionPolicy(t *testing.T) {
+	contestID := uint(7)
+
+	// previous is a submission by the same user to the same problem made ago
+	type previous struct {
+		ago     time.Duration
+		contest bool
+	}
+
+	tests := []struct {
+		name     string
+		problem  *models.SubmissionPolicy
+		contest  *models.SubmissionPolicy // nil means the submission is not in a contest
+		language string
+		code     string
+		files    map[string]string
+		previous []previous
+		counted  bool
+		wantCode string // "" means allowed
+	}{
+		{
+			name:     "no policy",
+			language: "cpp",
+			code:     "int main() {}",
+			counted:  true,
+		},
+		{
+			name:     "unsupported language",
+			language: "rust",
+			code:     "fn main() {}",
+			wantCode: PolicyLanguage,
+		},
+		{
+			name:     "language not allowed by problem",
+			problem:  &models.SubmissionPolicy{Languages: []string{"cpp"}},
+			contest:  &models.SubmissionPolicy{Languages: []string{"cpp", "python"}},
+			language: "python",
+			code:     "print()",
+			wantCode: PolicyLanguage,
+		},
+		{
+			name:     "language not allowed by contest",
+			problem:  &models.SubmissionPolicy{Languages: []string{"cpp", "python"}},
+			contest:  &models.SubmissionPolicy{Languages: []string{"python"}},
+			language: "cpp",
+			code:     "int main() {}",
+			wantCode: PolicyLanguage,
+		},
+		{
+			name:     "language allowed by both",
+			problem:  &models.SubmissionPolicy{Languages: []string{"cpp", "python"}},
+			contest:  &models.SubmissionPolicy{Languages: []string{"python", "java"}},
+			language: "python",
+			code:     "print()",
+		},
+		{
+			name:     "default size limit",
+			language: "cpp",
+			code:     strings.Repeat("x", DefaultMaxSourceSize+1),
+			wantCode: PolicySourceSize,
+		},
+		{
+			name:     "policy cannot raise the size limit",
+			problem:  &models.SubmissionPolicy{MaxSourceSize: 2 * DefaultMaxSourceSize},
+			language: "cpp",
+			code:     strings.Repeat("x", DefaultMaxSourceSize+1),
+			wantCode: PolicySourceSize,
+		},
+		{
+			name:     "multi-file cap",
+			language: "cpp",
+			files:    map[string]string{"main.cpp": strings.Repeat("x", 2*DefaultMaxSourceSize)},
+		},
+		{
+			name:     "multi-file limited to the policy's size",
+			problem:  &models.SubmissionPolicy{MaxSourceSize: DefaultMaxSourceSize},
+			language: "cpp",
+			files:    map[string]string{"main.cpp": strings.Repeat("x", DefaultMaxSourceSize+1)},
+			wantCode: PolicySourceSize,
+		},
+		{
+			name:     "contest size stricter than problem",
+			problem:  &models.SubmissionPolicy{MaxSourceSize: 1000},
+			contest:  &models.SubmissionPolicy{MaxSourceSize: 500},
+			language: "cpp",
+			code:     strings.Repeat("x", 600),
+			wantCode: PolicySourceSize,
+		},
+		{
+			name:     "problem size stricter than contest",
+			problem:  &models.SubmissionPolicy{MaxSourceSize: 500},
+			contest:  &models.SubmissionPolicy{MaxSourceSize: 1000},
+			language: "cpp",
+			code:     strings.Repeat("x", 600),
+			wantCode: PolicySourceSize,
+		},
+		{
+			name:     "cooldown",
+			problem:  &models.SubmissionPolicy{MinInterval: 60},
+			language: "cpp",
+			code:     "int main() {}",
+			previous: []previous{{ago: 10 * time.Second}},
+			counted:  true,
+			wantCode: PolicyCooldown,
+		},
+		{
+			name:     "cooldown over",
+			problem:  &models.SubmissionPolicy{MinInterval: 60},
+			language: "cpp",
+			code:     "int main() {}",
+			previous: []previous{{ago: 2 * time.Minute}},
+			counted:  true,
+		},
+		{
+			name:     "contest cooldown stricter than problem",
+			problem:  &models.SubmissionPolicy{MinInterval: 5},
+			contest:  &models.SubmissionPolicy{MinInterval: 60},
+			language: "cpp",
+			code:     "int main() {}",
+			previous: []previous{{ago: 10 * time.Second, contest: true}},
+			counted:  true,
+			wantCode: PolicyCooldown,
+		},
+		{
+			name:     "submission limit",
+			problem:  &models.SubmissionPolicy{MaxSubmissions: 2},
+			language: "cpp",
+			code:     "int main() {}",
+			previous: []previous{{ago: time.Hour}, {ago: time.Minute}},
+			counted:  true,
+			wantCode: PolicySubmissions,
+		},
+		{
+			name:     "contest limit counts contest submissions only",
+			contest:  &models.SubmissionPolicy{MaxSubmissions: 1},
+			language: "cpp",
+			code:     "int main() {}",
+			previous: []previous{{ago: time.Hour}},
+			counted:  true,
+		},
+		{
+			name:     "contest limit",
+			contest:  &models.SubmissionPolicy{MaxSubmissions: 1},
+			language: "cpp",
+			code:     "int main() {}",
+			previous: []previous{{ago: time.Hour, contest: true}},
+			counted:  true,
+			wantCode: PolicySubmissions,
+		},
+		{
+			name:     "sample runs skip the limits",
+			problem:  &models.SubmissionPolicy{MinInterval: 60, MaxSubmissions: 1},
+			language: "cpp",
+			code:     "int main() {}",
+			previous: []previous{{ago: time.Second}},
+			counted:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, &models.Submission{})
+
+			problem := &models.Problem{ID: 1, Policy: tt.problem}
+			var contest *models.Contest
+			if tt.contest != nil {
+				contest = &models.Contest{ID: contestID, Policy: tt.contest}
+			}
+
+			for _, p := range tt.previous {
+				earlier := models.Submission{UserID: 1, ProblemID: problem.ID, Language: "cpp", Code: "x",
+					Status: "accepted", CreatedAt: time.Now().Add(-p.ago)}
+				if p.contest {
+					earlier.ContestID = &contestID
+				}
+				if err := db.Omit(clause.Associations).Create(&earlier).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			submission := &models.Submission{UserID: 1, ProblemID: problem.ID, Language: tt.language, Code: tt.code, Files: tt.files}
+			if contest != nil {
+				submission.ContestID = &contest.ID
+			}
+
+			err := CheckSubmissionPolicy(db, problem, contest, submission, tt.counted)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("CheckSubmissionPolicy() = %v, want allowed", err)
+				}
+				return
+			}
+			violation, ok := err.(*PolicyViolation)
+			if !ok || violation.Code != tt.wantCode {
+				t.Fatalf("CheckSubmissionPolicy() = %v, want a %s violation", err, tt.wantCode)
+			}
+			if violation.Code == PolicyCooldown {
+				// The latest submission was 10 seconds ago with a minute's interval
+				if violation.RetryAfter <= 45*time.Second || violation.RetryAfter > 50*time.Second {
+					t.Errorf("RetryAfter = %v, want about 50s", violation.RetryAfter)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// profileCacheTTL bounds how stale a cached profile may get. Profiles are
+// also dropped as soon as one of the user's submissions is judged.
+const profileCacheTTL = 5 * time.Minute
+
+// profileCacheSize bounds the number of cached profiles. The least recently
+// used one is evicted to make room.
+const profileCacheSize = 10000
+
+// heatmapDays is how far back the submission heatmap reaches.
+const heatmapDays = 365
+
+type ProblemSummary struct {
+	ID         uint   `json:"id"`
+	Title      string `json:"title"`
+	Difficulty string `json:"difficulty"`
+	Points     int    `json:"points"`
+}
+
+type HeatmapDay struct {
+	Date  string `json:"date"` // YYYY-MM-DD in UTC
+	Count int    `json:"count"`
+}
+
+type ContestResult struct {
+	ContestID    uint      `json:"contest_id"`
+	Title        string    `json:"title"`
+	StartTime    time.Time `json:"start_time"`
+	Solved       int       `json:"solved"`
+	Submissions  int       `json:"submissions"`
+	Rank         int       `json:"rank"`
+	Participants int       `json:"participants"`
+}
+
+type UserProfile struct {
+	ID            uint             `json:"id"`
+	Username      string           `json:"username"`
+	Rating        int              `json:"rating"`
+	JoinedAt      time.Time        `json:"joined_at"`
+	Solved        []ProblemSummary `json:"solved"`
+	Attempted     []ProblemSummary `json:"attempted"` // tried but never accepted
+	ByDifficulty  map[string]int   `json:"by_difficulty"`
+	ByTag         map[string]int   `json:"by_tag"`
+	Heatmap       []HeatmapDay     `json:"heatmap"`
+	CurrentStreak int              `json:"current_streak"` // in days
+	LongestStreak int              `json:"longest_streak"` // in days
+	Contests      []ContestResult  `json:"contests"`
+	GeneratedAt   time.Time        `json:"generated_at"`
+}
+
+// profileCache holds recently viewed profiles, most recently used at the
+// front of order.
+var profileCache = struct {
+	sync.Mutex
+	order   *list.List
+	entries map[uint]*list.Element
+}{order: list.New(), entries: make(map[uint]*list.Element)}
+
+// InvalidateProfile drops the cached profile of a user.
+func InvalidateProfile(userID uint) {
+	profileCache.Lock()
+	defer profileCache.Unlock()
+	if elem, ok := profileCache.entries[userID]; ok {
+		profileCache.order.Remove(elem)
+		delete(profileCache.entries, userID)
+	}
+}
+
+// cachedProfile returns the user's cached profile unless it is missing or
+// older than profileCacheTTL.
+func cachedProfile(userID uint) (*UserProfile, bool) {
+	profileCache.Lock()
+	defer profileCache.Unlock()
+	elem, ok := profileCache.entries[userID]
+	if !ok {
+		return nil, false
+	}
+	profile := elem.Value.(*UserProfile)
+	if time.Since(profile.GeneratedAt) >= profileCacheTTL {
+		profileCache.order.Remove(elem)
+		delete(profileCache.entries, userID)
+		return nil, false
+	}
+	profileCache.order.MoveToFront(elem)
+	return profile, true
+}
+
+// cacheProfile stores a profile, evicting the least recently used ones
+// beyond profileCacheSize.
+func cacheProfile(profile *UserProfile) {
+	profileCache.Lock()
+	defer profileCache.Unlock()
+	if elem, ok := profileCache.entries[profile.ID]; ok {
+		elem.Value = profile
+		profileCache.order.MoveToFront(elem)
+		return
+	}
+	profileCache.entries[profile.ID] = profileCache.order.PushFront(profile)
+	for profileCache.order.Len() > profileCacheSize {
+		oldest := profileCache.order.Back()
+		profileCache.order.Remove(oldest)
+		delete(profileCache.entries, oldest.Value.(*UserProfile).ID)
+	}
+}
+
+// GetUserProfile returns the public profile of user, computing it from
+// submissions when no fresh copy is cached.
+func GetUserProfile(db *gorm.DB, user *models.User) (*UserProfile, error) {
+	if cached, ok := cachedProfile(user.ID); ok {
+		return cached, nil
+	}
+
+	profile, err := buildProfile(db, user)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheProfile(profile)
+	return profile, nil
+}
+
+func buildProfile(db *gorm.DB, user *models.User) (*UserProfile, error) {
+	profile := &UserProfile{
+		ID:           user.ID,
+		Username:     user.Username,
+		Rating:       user.Rating,
+		JoinedAt:     user.CreatedAt,
+		Solved:       []ProblemSummary{},
+		Attempted:    []ProblemSummary{},
+		ByDifficulty: map[string]int{},
+		ByTag:        map[string]int{},
+		Contests:     []ContestResult{},
+		GeneratedAt:  time.Now(),
+	}
+
+	solved := db.Table("submissions").Select("problem_id").
+		Where("user_id = ? AND status = ?", user.ID, "accepted")
+
+	if err := db.Model(&models.Problem{}).
+		Select("id, title, difficulty, points").
+		Where("id IN (?)", solved).
+		Order("id").
+		Scan(&profile.Solved).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Model(&models.Problem{}).
+		Select("id, title, difficulty, points").
+		Where("id IN (?) AND id NOT IN (?)",
+			db.Table("submissions").Select("problem_id").Where("user_id = ?", user.ID), solved).
+		Order("id").
+		Scan(&profile.Attempted).Error; err != nil {
+		return nil, err
+	}
+
+	for _, p := range profile.Solved {
+		profile.ByDifficulty[p.Difficulty]++
+	}
+
+	var tagCounts []struct {
+		Name  string
+		Count int
+	}
+	if err := db.Table("problem_tags").
+		Select("tags.name AS name, COUNT(*) AS count").
+		Joins("JOIN tags ON tags.id = problem_tags.tag_id").
+		Where("problem_tags.problem_id IN (?)", solved).
+		Group("tags.name").
+		Scan(&tagCounts).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range tagCounts {
+		profile.ByTag[t.Name] = t.Count
+	}
+
+	if err := fillActivity(db, profile); err != nil {
+		return nil, err
+	}
+
+	if err := fillContests(db, profile); err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// fillActivity builds the heatmap for the last heatmapDays days and the
+// streaks of consecutive days with at least one submission.
+func fillActivity(db *gorm.DB, profile *UserProfile) error {
+	var days []struct {
+		Day   time.Time
+		Count int
+	}
+	if err := db.Model(&models.Submission{}).
+		Select("DATE(created_at AT TIME ZONE 'UTC') AS day, COUNT(*) AS count").
+		Where("user_id = ?", profile.ID).
+		Group("day").
+		Order("day").
+		Scan(&days).Error; err != nil {
+		return err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, -heatmapDays+1)
+
+	counts := make(map[string]int)
+	run := 0
+	var previous time.Time
+	for _, d := range days {
+		day := d.Day.UTC().Truncate(24 * time.Hour)
+		if !previous.IsZero() && day.Sub(previous) == 24*time.Hour {
+			run++
+		} else {
+			run = 1
+		}
+		previous = day
+		if run > profile.LongestStreak {
+			profile.LongestStreak = run
+		}
+		if !day.Before(since) {
+			counts[day.Format("2006-01-02")] = d.Count
+		}
+	}
+
+	// A streak is still current until a full day passes without submissions
+	if !previous.IsZero() && today.Sub(previous) <= 24*time.Hour {
+		profile.CurrentStreak = run
+	}
+
+	profile.Heatmap = make([]HeatmapDay, 0, heatmapDays)
+	for day := since; !day.After(today); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		profile.Heatmap = append(profile.Heatmap, HeatmapDay{Date: date, Count: counts[date]})
+	}
+
+	return nil
+}
+
+// fillContests lists the contests the user registered for, ranking
+// participants by the number of contest problems they solved. The standings
+// of every such contest are loaded in a single query.
+func fillContests(db *gorm.DB, profile *UserProfile) error {
+	var standings []struct {
+		ContestID   uint
+		Title       string
+		StartTime   time.Time
+		UserID      uint
+		Solved      int
+		Submissions int
+	}
+	if err := db.Table("contest_users").
+		Select("contests.id AS contest_id, contests.title AS title, contests.start_time AS start_time, "+
+			"contest_users.user_id AS user_id, "+
+			"COUNT(DISTINCT CASE WHEN submissions.status = 'accepted' THEN submissions.problem_id END) AS solved, "+
+			"COUNT(submissions.id) AS submissions").
+		Joins("JOIN contests ON contests.id = contest_users.contest_id").
+		Joins("LEFT JOIN submissions ON submissions.user_id = contest_users.user_id AND submissions.contest_id = contest_users.contest_id").
+		Where("contest_users.contest_id IN (?)",
+			db.Table("contest_users").Select("contest_id").Where("user_id = ?", profile.ID)).
+		Group("contests.id, contests.title, contests.start_time, contest_users.user_id").
+		Order("contests.start_time DESC, contests.id").
+		Scan(&standings).Error; err != nil {
+		return err
+	}
+
+	// Rows come grouped by contest, newest first
+	index := make(map[uint]int)
+	for _, s := range standings {
+		i, ok := index[s.ContestID]
+		if !ok {
+			i = len(profile.Contests)
+			index[s.ContestID] = i
+			profile.Contests = append(profile.Contests, ContestResult{
+				ContestID: s.ContestID,
+				Title:     s.Title,
+				StartTime: s.StartTime,
+				Rank:      1,
+			})
+		}
+		result := &profile.Contests[i]
+		result.Participants++
+		if s.UserID == profile.ID {
+			result.Solved = s.Solved
+			result.Submissions = s.Submissions
+		}
+	}
+	for _, s := range standings {
+		result := &profile.Contests[index[s.ContestID]]
+		if s.Solved > result.Solved {
+			result.Rank++
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/pkg/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// programLimits bound a single run of an authoring program.
+var programLimits = RunLimits{
+	Time:   10 * time.Second,
+	Memory: 1024,
+	Output: maxProgramOutput,
+}
+
+// ValidationError reports the first test whose input a validator rejected.
+type ValidationError struct {
+	Test    int    `json:"test"` // 1-based position in the test set
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("test %d rejected by validator: %s", e.Test, e.Message)
+}
+
+// compiledProgram is an authoring program compiled in its own directory.
+type compiledProgram struct {
+	dir      string
+	language string
+	codeFile string
+}
+
+func (e *Evaluator) compileProgram(program *models.ProblemProgram) (*compiledProgram, error) {
+	dir, err := os.MkdirTemp(e.workDir, "program_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create program directory: %v", err)
+	}
+
+	codeFile := filepath.Join(dir, getSourceFileName(program.Language))
+	if err := os.WriteFile(codeFile, []byte(program.Source), 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to write %s %q: %v", program.Kind, program.Name, err)
+	}
+
+	if err := e.compile(program.Language, codeFile); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to compile %s %q: %v", program.Kind, program.Name, err)
+	}
+
+	return &compiledProgram{dir: dir, language: program.Language, codeFile: codeFile}, nil
+}
+
+func (e *Evaluator) runProgram(p *compiledProgram, stdin string, args ...string) ProgramRun {
+	cmd := runCommand(p.language, p.codeFile, args...)
+	if cmd == nil {
+		return ProgramRun{StartError: fmt.Errorf("unsupported language: %s", p.language)}
+	}
+	return e.execute(cmd, stdin, programLimits)
+}
+
+func (p *compiledProgram) cleanup() {
+	os.RemoveAll(p.dir)
+}
+
+// ValidateTests runs validator against the input of every test and returns a
+// *ValidationError for the first one it rejects.
+func (e *Evaluator) ValidateTests(validator *models.ProblemProgram, tests []models.TestCase) error {
+	program, err := e.compileProgram(validator)
+	if err != nil {
+		return err
+	}
+	defer program.cleanup()
+
+	for i, tc := range tests {
+		run := e.runProgram(program, tc.Input)
+		switch {
+		case run.StartError != nil:
+			return fmt.Errorf("failed to run validator: %v", run.StartError)
+		case run.TimedOut:
+			return &ValidationError{Test: i + 1, Message: "validator timed out"}
+		case run.ExitCode != 0:
+			message := strings.TrimSpace(run.Stderr)
+			if message == "" {
+				message = fmt.Sprintf("validator exited with code %d", run.ExitCode)
+			}
+			return &ValidationError{Test: i + 1, Message: message}
+		}
+	}
+
+	return nil
+}
+
+// GenerateTests executes a test script and returns the generated tests in
+// script order. Every line that is neither blank nor a "#" comment names a
+// generator followed by its arguments, e.g. "gen_tree 100000 7". Arguments
+// are passed verbatim, so generators are expected to seed their random source
+// from them and the same script always materializes the same tests. Expected
+// outputs are produced by running solution on each generated input.
+func (e *Evaluator) GenerateTests(script string, generators []models.ProblemProgram, solution *models.ProblemProgram) ([]models.TestCase, error) {
+	if strings.TrimSpace(script) == "" {
+		return nil, nil
+	}
+	if solution == nil {
+		return nil, fmt.Errorf("a main solution is required to produce answers for generated tests")
+	}
+
+	byName := make(map[string]*models.ProblemProgram, len(generators))
+	for i := range generators {
+		byName[generators[i].Name] = &generators[i]
+	}
+
+	answers, err := e.compileProgram(solution)
+	if err != nil {
+		return nil, err
+	}
+	defer answers.cleanup()
+
+	compiled := make(map[string]*compiledProgram)
+	defer func() {
+		for _, p := range compiled {
+			p.cleanup()
+		}
+	}()
+
+	var tests []models.TestCase
+	for n, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		name, args := fields[0], fields[1:]
+
+		program, ok := compiled[name]
+		if !ok {
+			generator, found := byName[name]
+			if !found {
+				return nil, fmt.Errorf("line %d: unknown generator %q", n+1, name)
+			}
+			program, err = e.compileProgram(generator)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+1, err)
+			}
+			compiled[name] = program
+		}
+
+		input := e.runProgram(program, "", args...)
+		if err := runError(input); err != nil {
+			return nil, fmt.Errorf("line %d: generator %q %v", n+1, name, err)
+		}
+
+		output := e.runProgram(answers, input.Stdout)
+		if err := runError(output); err != nil {
+			return nil, fmt.Errorf("line %d: solution %v", n+1, err)
+		}
+
+		tests = append(tests, models.TestCase{
+			Input:  input.Stdout,
+			Output: output.Stdout,
+		})
+	}
+
+	return tests, nil
+}
+
+// PrepareTests completes the tests of a revision saved with TestStatus
+// generating: it appends the tests produced by the revision's test script,
+// checks them with the validator, marks the revision ready and makes it the
+// problem's current revision. The hand-written tests were validated when the
+// revision was saved. A script or validator failure marks it failed with the
+// reason in TestError and leaves the problem on its previous revision, as
+// does a newer revision of the problem being saved in the meantime, since
+// the problem's programs may no longer be the ones the revision was saved
+// with. Only database errors are returned.
+func (e *Evaluator) PrepareTests(ctx context.Context, revisionID uint) (*models.ProblemRevision, error) {
+	db := database.DB.WithContext(ctx)
+
+	var revision models.ProblemRevision
+	if err := preloadContent(db).First(&revision, revisionID).Error; err != nil {
+		return nil, err
+	}
+	if revision.TestStatus != models.TestsGenerating {
+		return &revision, nil
+	}
+
+	if newer, err := superseded(db, &revision); err != nil {
+		return nil, err
+	} else if newer {
+		return &revision, failTests(db, &revision, supersededMessage)
+	}
+
+	var programs []models.ProblemProgram
+	if err := db.Where("problem_id = ?", revision.ProblemID).Order("id").Find(&programs).Error; err != nil {
+		return nil, err
+	}
+	var generators []models.ProblemProgram
+	var solution, validator *models.ProblemProgram
+	for i := range programs {
+		switch {
+		case programs[i].Kind == models.ProgramGenerator:
+			generators = append(generators, programs[i])
+		case programs[i].Kind == models.ProgramValidator:
+			validator = &programs[i]
+		case programs[i].Kind == models.ProgramSolution && programs[i].Expected == models.ExpectMain:
+			solution = &programs[i]
+		}
+	}
+
+	// Function-style problems need the main solution spliced into its harness
+	if solution != nil {
+		code, err := WrapSource(db, &revision, solution.Language, solution.Source)
+		if err != nil {
+			return &revision, failTests(db, &revision, fmt.Sprintf("failed to wrap solution %q: %v", solution.Name, err))
+		}
+		solution.Source = code
+	}
+
+	generated, err := e.GenerateTests(revision.TestScript, generators, solution)
+	if err != nil {
+		return &revision, failTests(db, &revision, "failed to generate tests: "+err.Error())
+	}
+	for i := range generated {
+		generated[i].ProblemID = revision.ProblemID
+		generated[i].RevisionID = revision.ID
+	}
+
+	tests := append(revision.TestCases, generated...)
+	if len(tests) == 0 {
+		return &revision, failTests(db, &revision, "at least one test case is required")
+	}
+	if validator != nil {
+		if err := e.ValidateTests(validator, generated); err != nil {
+			// Number the test by its position in the whole test set
+			if verr, ok := err.(*ValidationError); ok {
+				verr.Test += len(revision.TestCases)
+			}
+			return &revision, failTests(db, &revision, err.Error())
+		}
+	}
+
+	// The problem row is locked so a concurrent save either comes first and
+	// supersedes the revision or is numbered after it
+	failed := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var problem models.Problem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&problem, revision.ProblemID).Error; err != nil {
+			return err
+		}
+		if newer, err := superseded(tx, &revision); err != nil {
+			return err
+		} else if newer {
+			failed = true
+			return failTests(tx, &revision, supersededMessage)
+		}
+
+		if len(generated) > 0 {
+			if err := tx.Create(&generated).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&revision).Update("test_status", models.TestsReady).Error; err != nil {
+			return err
+		}
+		return makeCurrent(tx, &problem, &revision)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if failed {
+		return &revision, nil
+	}
+	revision.TestStatus = models.TestsReady
+	revision.TestCases = tests
+	return &revision, nil
+}
+
+// supersededMessage is the TestError of a revision replaced before it was ready.
+const supersededMessage = "superseded by a newer revision before its tests were generated"
+
+func failTests(db *gorm.DB, revision *models.ProblemRevision, message string) error {
+	revision.TestStatus = models.TestsFailed
+	revision.TestError = message
+	return db.Model(revision).Updates(map[string]interface{}{
+		"test_status": models.TestsFailed,
+		"test_error":  message,
+	}).Error
+}
+
+// runError describes why an authoring program run failed, or returns nil.
+func runError(run ProgramRun) error {
+	switch {
+	case run.StartError != nil:
+		return fmt.Errorf("failed to start: %v", run.StartError)
+	case run.TimedOut:
+		return fmt.Errorf("timed out")
+	case run.OutputLimitExceeded:
+		return fmt.Errorf("exceeded the %d MB output limit", maxProgramOutput>>20)
+	case run.ExitCode != 0:
+		return fmt.Errorf("exited with code %d: %s", run.ExitCode, strings.TrimSpace(run.Stderr))
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Permission names an action that roles allow.
+type Permission string
+
+const (
+	PermProblemCreate Permission = "problem.create"
+	PermProblemEdit   Permission = "problem.edit" // statement, tests, limits, attachments, rollback
+	PermProblemDelete Permission = "problem.delete"
+	PermProblemManage Permission = "problem.manage" // rejudge, statistics rebuild
+	PermProblemVerify Permission = "problem.verify" // revisions and reference solution checks
+	PermProblemGrant  Permission = "problem.grant"  // add and remove co-authors and testers
+
+	PermContestCreate Permission = "contest.create"
+	PermContestEdit   Permission = "contest.edit"
+	PermContestDelete Permission = "contest.delete"
+	PermContestReview Permission = "contest.review" // plagiarism reports
+	PermContestGrant  Permission = "contest.grant"  // add and remove co-managers and judges
+
+	PermUserManage Permission = "user.manage"
+)
+
+// Platform roles, stored in User.Role.
+const (
+	RoleAdmin          = "admin"
+	RoleSetter         = "setter"
+	RoleContestManager = "contest_manager"
+	RoleJudge          = "judge"
+	RoleTester         = "tester"
+	RoleContestant     = "contestant"
+	RoleUser           = "user" // default for existing accounts, same as RoleContestant
+)
+
+// Resource types that accept grants.
+const (
+	ResourceProblem = "problem"
+	ResourceContest = "contest"
+)
+
+// Per-resource roles. Owner is implied by being the resource's creator.
+const (
+	GrantOwner     = "owner"
+	GrantCoauthor  = "coauthor"
+	GrantTester    = "tester"
+	GrantCoManager = "comanager"
+	GrantJudge     = "judge"
+)
+
+var ErrUnknownRole = errors.New("unknown role")
+
+// RolePermissions lists what each platform role may do on every resource.
+// Admins may do everything.
+var RolePermissions = map[string][]Permission{
+	RoleAdmin:          nil,
+	RoleSetter:         {PermProblemCreate},
+	RoleContestManager: {PermContestCreate},
+	RoleJudge:          {PermContestReview},
+	RoleTester:         {PermProblemVerify},
+	RoleContestant:     {},
+	RoleUser:           {},
+}
+
+// ResourceRoles lists what a per-resource role may do on that resource.
+var ResourceRoles = map[string]map[string][]Permission{
+	ResourceProblem: {
+		GrantOwner:    {PermProblemEdit, PermProblemDelete, PermProblemManage, PermProblemVerify, PermProblemGrant},
+		GrantCoauthor: {PermProblemEdit, PermProblemManage, PermProblemVerify},
+		GrantTester:   {PermProblemVerify},
+	},
+	ResourceContest: {
+		GrantOwner:     {PermContestEdit, PermContestDelete, PermContestReview, PermContestGrant},
+		GrantCoManager: {PermContestEdit, PermContestReview},
+		GrantJudge:     {PermContestReview},
+	},
+}
+
+// Resource identifies a problem or contest a permission is checked on.
+type Resource struct {
+	Type    string
+	ID      uint
+	OwnerID uint
+}
+
+// LoadResource looks up the owner of a problem or contest.
+func LoadResource(db *gorm.DB, resourceType string, id string) (*Resource, error) {
+	var record struct {
+		ID        uint
+		CreatedBy uint
+	}
+
+	var query *gorm.DB
+	switch resourceType {
+	case ResourceProblem:
+		query = db.Model(&models.Problem{})
+	case ResourceContest:
+		query = db.Model(&models.Contest{})
+	default:
+		return nil, errors.New("unknown resource type")
+	}
+
+	if err := query.Select("id", "created_by").Where("id = ?", id).Take(&record).Error; err != nil {
+		return nil, err
+	}
+	return &Resource{Type: resourceType, ID: record.ID, OwnerID: record.CreatedBy}, nil
+}
+
+func hasPermission(perms []Permission, perm Permission) bool {
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether user may perform perm, on resource when it is not
+// nil. Platform roles apply everywhere; ownership and grants only on their
+// own resource.
+func Can(db *gorm.DB, user *models.User, perm Permission, resource *Resource) bool {
+	if RolePermitted(db, user) && (user.Role == RoleAdmin || hasPermission(RolePermissions[user.Role], perm)) {
+		return true
+	}
+	if resource == nil {
+		return false
+	}
+
+	roles := ResourceRoles[resource.Type]
+	if resource.OwnerID == user.ID && hasPermission(roles[GrantOwner], perm) {
+		return true
+	}
+
+	var grants []models.ResourceGrant
+	db.Where("resource_type = ? AND resource_id = ? AND user_id = ?", resource.Type, resource.ID, user.ID).Find(&grants)
+	for _, g := range grants {
+		if hasPermission(roles[g.Role], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// RolePermitted reports whether the user's platform role is in effect.
+// Roles that require two-factor authentication grant nothing without it;
+// ownership and grants still apply.
+func RolePermitted(db *gorm.DB, user *models.User) bool {
+	return !TwoFactorRequired(user.Role) || TwoFactorEnabled(db, user.ID)
+}
+
+// ValidateRole checks a platform role name.
+func ValidateRole(role string) error {
+	if _, ok := RolePermissions[role]; !ok {
+		return ErrUnknownRole
+	}
+	return nil
+}
+
+// ValidateGrantRole checks a per-resource role name. Ownership cannot be
+// granted.
+func ValidateGrantRole(resourceType, role string) error {
+	if _, ok := ResourceRoles[resourceType][role]; !ok || role == GrantOwner {
+		return ErrUnknownRole
+	}
+	return nil
+}
@@ -0,0 +1,387 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrNoRevision = errors.New("problem has no revision")
+
+// FieldChange describes a single statement or limit field that differs
+// between two revisions.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// TestCaseChange describes a test present in both revisions at the same
+// position whose contents differ.
+type TestCaseChange struct {
+	Index int             `json:"index"`
+	From  models.TestCase `json:"from"`
+	To    models.TestCase `json:"to"`
+}
+
+// StatementChange lists the sections of one translation that differ between
+// two revisions. Translations only present on one side have From or To nil.
+type StatementChange struct {
+	Language string                   `json:"language"`
+	Sections []string                 `json:"sections"`
+	From     *models.ProblemStatement `json:"from"`
+	To       *models.ProblemStatement `json:"to"`
+}
+
+// HarnessChange lists the parts of one language's harness that differ
+// between two revisions. Harnesses only present on one side have From or To
+// nil.
+type HarnessChange struct {
+	Language string                  `json:"language"`
+	Sections []string                `json:"sections"`
+	From     *models.RevisionHarness `json:"from"`
+	To       *models.RevisionHarness `json:"to"`
+}
+
+type RevisionDiff struct {
+	From         int               `json:"from"`
+	To           int               `json:"to"`
+	Fields       []FieldChange     `json:"fields"`
+	Statements   []StatementChange `json:"statements"`
+	Harnesses    []HarnessChange   `json:"harnesses"`
+	TestsAdded   []models.TestCase `json:"tests_added"`
+	TestsRemoved []models.TestCase `json:"tests_removed"`
+	TestsChanged []TestCaseChange  `json:"tests_changed"`
+}
+
+// RevisionContent is the versioned material stored alongside a revision's
+// problem fields.
+type RevisionContent struct {
+	Tests      []models.TestCase
+	Statements []models.ProblemStatement
+	Harnesses  []models.ProblemHarness
+
+	// Generate leaves the revision generating for PrepareTests to add the
+	// tests of the problem's test script and run its validator
+	Generate bool
+}
+
+// preloadContent loads a revision's tests and statements in a stable order.
+func preloadContent(db *gorm.DB) *gorm.DB {
+	ordered := func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}
+	return db.Preload("TestCases", ordered).Preload("Statements", ordered).Preload("Harnesses", ordered)
+}
+
+// CreateRevision snapshots the problem's current fields together with content
+// as the next revision and makes it the problem's current revision. A
+// revision whose tests still have to be generated only becomes current once
+// PrepareTests has made them ready, so submissions keep being judged against
+// the previous revision meanwhile; a problem without a revision takes it
+// right away. Content is always inserted as new rows so earlier revisions
+// are never modified.
+// The problem row stays locked until tx ends so that concurrent saves are
+// numbered one after the other; tx must be a transaction.
+func CreateRevision(tx *gorm.DB, problem *models.Problem, content RevisionContent, userID uint, message string) (*models.ProblemRevision, error) {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").
+		Take(&models.Problem{}, problem.ID).Error; err != nil {
+		return nil, fmt.Errorf("failed to lock problem: %v", err)
+	}
+
+	var last int
+	if err := tx.Model(&models.ProblemRevision{}).
+		Where("problem_id = ?", problem.ID).
+		Select("COALESCE(MAX(number), 0)").
+		Scan(&last).Error; err != nil {
+		return nil, err
+	}
+
+	revision := models.ProblemRevision{
+		ProblemID:   problem.ID,
+		Number:      last + 1,
+		Title:       problem.Title,
+		Description: problem.Description,
+		Difficulty:  problem.Difficulty,
+		TimeLimit:   problem.TimeLimit,
+		MemoryLimit: problem.MemoryLimit,
+		Checker:     problem.Checker,
+		IOMode:      problem.IOMode,
+		InputFile:   problem.InputFile,
+		OutputFile:  problem.OutputFile,
+		Points:      problem.Points,
+		TestScript:  problem.TestScript,
+		Signature:   problem.Signature,
+		Policy:      problem.Policy,
+		Message:     message,
+		CreatedBy:   userID,
+		TestStatus:  models.TestsReady,
+	}
+	if content.Generate {
+		revision.TestStatus = models.TestsGenerating
+	}
+	for _, st := range content.Statements {
+		revision.Statements = append(revision.Statements, models.ProblemStatement{
+			ProblemID:    problem.ID,
+			Language:     st.Language,
+			Title:        st.Title,
+			Legend:       st.Legend,
+			InputFormat:  st.InputFormat,
+			OutputFormat: st.OutputFormat,
+			Notes:        st.Notes,
+			Scoring:      st.Scoring,
+		})
+	}
+	for _, h := range content.Harnesses {
+		revision.Harnesses = append(revision.Harnesses, models.RevisionHarness{
+			Language: h.Language,
+			Template: h.Template,
+			Harness:  h.Harness,
+		})
+	}
+	for _, tc := range content.Tests {
+		revision.TestCases = append(revision.TestCases, models.TestCase{
+			ProblemID: problem.ID,
+			Input:     tc.Input,
+			Output:    tc.Output,
+			IsSample:  tc.IsSample,
+		})
+	}
+
+	if err := tx.Create(&revision).Error; err != nil {
+		return nil, fmt.Errorf("failed to create revision: %v", err)
+	}
+
+	if revision.TestStatus == models.TestsReady || problem.RevisionID == 0 {
+		if err := makeCurrent(tx, problem, &revision); err != nil {
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// makeCurrent points problem at revision.
+func makeCurrent(tx *gorm.DB, problem *models.Problem, revision *models.ProblemRevision) error {
+	problem.RevisionID = revision.ID
+	if err := tx.Model(problem).Update("revision_id", revision.ID).Error; err != nil {
+		return fmt.Errorf("failed to update current revision: %v", err)
+	}
+	return nil
+}
+
+// superseded reports whether a newer revision of revision's problem exists.
+func superseded(db *gorm.DB, revision *models.ProblemRevision) (bool, error) {
+	var newer int64
+	err := db.Model(&models.ProblemRevision{}).
+		Where("problem_id = ? AND number > ?", revision.ProblemID, revision.Number).
+		Count(&newer).Error
+	return newer > 0, err
+}
+
+// GetRevision loads revision number of a problem together with its tests.
+func GetRevision(db *gorm.DB, problemID uint, number int) (*models.ProblemRevision, error) {
+	var revision models.ProblemRevision
+	if err := preloadContent(db).Where("problem_id = ? AND number = ?", problemID, number).First(&revision).Error; err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// CurrentRevision returns the revision a new submission to problem should
+// be judged against.
+func CurrentRevision(db *gorm.DB, problem *models.Problem) (*models.ProblemRevision, error) {
+	if problem.RevisionID == 0 {
+		return nil, ErrNoRevision
+	}
+
+	var revision models.ProblemRevision
+	if err := preloadContent(db).First(&revision, problem.RevisionID).Error; err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// BackfillRevisions gives problems created before revisions existed their
+// revision 1. Their tests are adopted rather than copied so results of
+// earlier submissions keep pointing at the same test cases. It runs at
+// startup, before requests are served.
+func BackfillRevisions(db *gorm.DB) error {
+	var problems []models.Problem
+	if err := db.Where("revision_id = 0 OR revision_id IS NULL").Find(&problems).Error; err != nil {
+		return err
+	}
+
+	for i := range problems {
+		problem := &problems[i]
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var harnesses []models.ProblemHarness
+			if err := tx.Where("problem_id = ?", problem.ID).Find(&harnesses).Error; err != nil {
+				return err
+			}
+			revision, err := CreateRevision(tx, problem, RevisionContent{Harnesses: harnesses}, problem.CreatedBy, "Initial revision")
+			if err != nil {
+				return err
+			}
+			return tx.Model(&models.TestCase{}).
+				Where("problem_id = ? AND (revision_id = 0 OR revision_id IS NULL)", problem.ID).
+				Update("revision_id", revision.ID).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to backfill revision of problem %d: %v", problem.ID, err)
+		}
+	}
+	return nil
+}
+
+// DiffRevisions compares two revisions field by field, their statements and
+// harnesses by language and their tests by position.
+func DiffRevisions(from, to *models.ProblemRevision) RevisionDiff {
+	diff := RevisionDiff{
+		From:         from.Number,
+		To:           to.Number,
+		Fields:       []FieldChange{},
+		Statements:   []StatementChange{},
+		Harnesses:    []HarnessChange{},
+		TestsAdded:   []models.TestCase{},
+		TestsRemoved: []models.TestCase{},
+		TestsChanged: []TestCaseChange{},
+	}
+
+	addField := func(field string, a, b interface{}) {
+		if a != b {
+			diff.Fields = append(diff.Fields, FieldChange{Field: field, From: a, To: b})
+		}
+	}
+	addField("title", from.Title, to.Title)
+	addField("description", from.Description, to.Description)
+	addField("difficulty", from.Difficulty, to.Difficulty)
+	addField("time_limit", from.TimeLimit, to.TimeLimit)
+	addField("memory_limit", from.MemoryLimit, to.MemoryLimit)
+	addField("checker", from.Checker, to.Checker)
+	addField("io_mode", from.IOMode, to.IOMode)
+	addField("input_file", from.InputFile, to.InputFile)
+	addField("output_file", from.OutputFile, to.OutputFile)
+	addField("points", from.Points, to.Points)
+	addField("test_script", from.TestScript, to.TestScript)
+
+	// Signatures and policies are compared by value
+	addJSONField := func(field string, a, b interface{}) {
+		x, _ := json.Marshal(a)
+		y, _ := json.Marshal(b)
+		if string(x) != string(y) {
+			diff.Fields = append(diff.Fields, FieldChange{Field: field, From: a, To: b})
+		}
+	}
+	addJSONField("signature", from.Signature, to.Signature)
+	addJSONField("policy", from.Policy, to.Policy)
+
+	diff.Statements = diffStatements(from.Statements, to.Statements)
+	diff.Harnesses = diffHarnesses(from.Harnesses, to.Harnesses)
+
+	for i := 0; i < len(from.TestCases) || i < len(to.TestCases); i++ {
+		switch {
+		case i >= len(from.TestCases):
+			diff.TestsAdded = append(diff.TestsAdded, to.TestCases[i])
+		case i >= len(to.TestCases):
+			diff.TestsRemoved = append(diff.TestsRemoved, from.TestCases[i])
+		default:
+			a, b := from.TestCases[i], to.TestCases[i]
+			if a.Input != b.Input || a.Output != b.Output || a.IsSample != b.IsSample {
+				diff.TestsChanged = append(diff.TestsChanged, TestCaseChange{Index: i, From: a, To: b})
+			}
+		}
+	}
+
+	return diff
+}
+
+// diffStatements pairs translations by language and reports changed sections.
+func diffStatements(from, to []models.ProblemStatement) []StatementChange {
+	changes := []StatementChange{}
+	byLanguage := make(map[string]*models.ProblemStatement, len(from))
+	for i := range from {
+		byLanguage[from[i].Language] = &from[i]
+	}
+
+	for i := range to {
+		b := &to[i]
+		a, ok := byLanguage[b.Language]
+		if !ok {
+			changes = append(changes, StatementChange{Language: b.Language, Sections: []string{}, To: b})
+			continue
+		}
+		delete(byLanguage, b.Language)
+
+		var sections []string
+		for _, s := range []struct {
+			name string
+			a, b string
+		}{
+			{"title", a.Title, b.Title},
+			{"legend", a.Legend, b.Legend},
+			{"input_format", a.InputFormat, b.InputFormat},
+			{"output_format", a.OutputFormat, b.OutputFormat},
+			{"notes", a.Notes, b.Notes},
+			{"scoring", a.Scoring, b.Scoring},
+		} {
+			if s.a != s.b {
+				sections = append(sections, s.name)
+			}
+		}
+		if len(sections) > 0 {
+			changes = append(changes, StatementChange{Language: b.Language, Sections: sections, From: a, To: b})
+		}
+	}
+
+	for i := range from {
+		if a, ok := byLanguage[from[i].Language]; ok {
+			changes = append(changes, StatementChange{Language: a.Language, Sections: []string{}, From: a})
+		}
+	}
+
+	return changes
+}
+
+// diffHarnesses pairs harnesses by language and reports changed parts.
+func diffHarnesses(from, to []models.RevisionHarness) []HarnessChange {
+	changes := []HarnessChange{}
+	byLanguage := make(map[string]*models.RevisionHarness, len(from))
+	for i := range from {
+		byLanguage[from[i].Language] = &from[i]
+	}
+
+	for i := range to {
+		b := &to[i]
+		a, ok := byLanguage[b.Language]
+		if !ok {
+			changes = append(changes, HarnessChange{Language: b.Language, Sections: []string{}, To: b})
+			continue
+		}
+		delete(byLanguage, b.Language)
+
+		var sections []string
+		if a.Template != b.Template {
+			sections = append(sections, "template")
+		}
+		if a.Harness != b.Harness {
+			sections = append(sections, "harness")
+		}
+		if len(sections) > 0 {
+			changes = append(changes, HarnessChange{Language: b.Language, Sections: sections, From: a, To: b})
+		}
+	}
+
+	for i := range from {
+		if a, ok := byLanguage[from[i].Language]; ok {
+			changes = append(changes, HarnessChange{Language: a.Language, Sections: []string{}, From: a})
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/onlinejudge/backend/internal/models"
+)
+
+// baselineProblem and baselineTestCase are the tables as they were before
+// problems had revisions.
+type baselineProblem struct {
+	ID          uint   `gorm:"primaryKey"`
+	Title       string `gorm:"not null"`
+	Description string `gorm:"type:text;not null"`
+	Difficulty  string `gorm:"not null"`
+	TimeLimit   int    `gorm:"not null"`
+	MemoryLimit int    `gorm:"not null"`
+	CreatedBy   uint   `gorm:"not null"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (baselineProblem) TableName() string { return "problems" }
+
+type baselineTestCase struct {
+	ID        uint   `gorm:"primaryKey"`
+	ProblemID uint   `gorm:"not null"`
+	Input     string `gorm:"type:text;not null"`
+	Output    string `gorm:"type:text;not null"`
+	IsSample  bool   `gorm:"default:false"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (baselineTestCase) TableName() string { return "test_cases" }
+
+func TestBackfillRevisionsAdoptsExistingTests(t *testing.T) {
+	db := newTestDB(t, &baselineProblem{}, &baselineTestCase{})
+
+	problem := baselineProblem{Title: "A+B", Description: "Add two numbers", Difficulty: "easy",
+		TimeLimit: 1000, MemoryLimit: 256, CreatedBy: 1}
+	if err := db.Create(&problem).Error; err != nil {
+		t.Fatal(err)
+	}
+	tests := []baselineTestCase{
+		{ProblemID: problem.ID, Input: "1 2", Output: "3", IsSample: true},
+		{ProblemID: problem.ID, Input: "2 2", Output: "4"},
+	}
+	if err := db.Create(&tests).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&models.Problem{}, &models.TestCase{}, &models.ProblemRevision{},
+		&models.ProblemStatement{}, &models.ProblemHarness{}, &models.RevisionHarness{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if err := BackfillRevisions(db); err != nil {
+		t.Fatalf("BackfillRevisions() = %v", err)
+	}
+
+	var migrated models.Problem
+	if err := db.First(&migrated, problem.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	revision, err := CurrentRevision(db, &migrated)
+	if err != nil {
+		t.Fatalf("CurrentRevision() = %v", err)
+	}
+	if revision.Number != 1 || revision.Title != "A+B" {
+		t.Errorf("backfilled revision = %d %q, want 1 %q", revision.Number, revision.Title, "A+B")
+	}
+	if len(revision.TestCases) != len(tests) {
+		t.Fatalf("backfilled revision has %d tests, want %d", len(revision.TestCases), len(tests))
+	}
+	for i, tc := range revision.TestCases {
+		if tc.ID != tests[i].ID {
+			t.Errorf("test %d is %d, want the existing test %d adopted", i, tc.ID, tests[i].ID)
+		}
+	}
+
+	// Running again leaves the problem alone
+	if err := BackfillRevisions(db); err != nil {
+		t.Fatalf("second BackfillRevisions() = %v", err)
+	}
+	var revisions int64
+	db.Model(&models.ProblemRevision{}).Where("problem_id = ?", problem.ID).Count(&revisions)
+	if revisions != 1 {
+		t.Errorf("problem has %d revisions after a second backfill, want 1", revisions)
+	}
+}
+
+func TestDiffRevisions(t *testing.T) {
+	base := func() *models.ProblemRevision {
+		return &models.ProblemRevision{
+			Number:      1,
+			Title:       "A+B",
+			TimeLimit:   1000,
+			MemoryLimit: 256,
+			Points:      100,
+			TestScript:  "gen 1",
+			Signature:   &models.FunctionSignature{Name: "add", Params: []models.FunctionParam{{Name: "a", Type: "int"}}, Returns: "int"},
+			Policy:      &models.SubmissionPolicy{Languages: []string{"cpp"}, MinInterval: 30},
+			Statements:  []models.ProblemStatement{{Language: "en", Title: "A+B", Legend: "Add"}},
+			Harnesses: []models.RevisionHarness{
+				{Language: "cpp", Template: "int add(int a) {}", Harness: "main {{solution}}"},
+				{Language: "python", Template: "def add(a):", Harness: "{{solution}}"},
+			},
+			TestCases: []models.TestCase{{Input: "1 2", Output: "3", IsSample: true}, {Input: "2 2", Output: "4"}},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		change     func(r *models.ProblemRevision)
+		fields     []string
+		statements []string // languages
+		harnesses  []string // language: sections
+		added      int
+		removed    int
+		changed    []int
+	}{
+		{
+			name:   "identical",
+			change: func(r *models.ProblemRevision) {},
+		},
+		{
+			name: "limits and points",
+			change: func(r *models.ProblemRevision) {
+				r.TimeLimit = 2000
+				r.Points = 200
+			},
+			fields: []string{"time_limit", "points"},
+		},
+		{
+			name:   "test script",
+			change: func(r *models.ProblemRevision) { r.TestScript = "gen 2" },
+			fields: []string{"test_script"},
+		},
+		{
+			name: "policy",
+			change: func(r *models.ProblemRevision) {
+				r.Policy = &models.SubmissionPolicy{Languages: []string{"cpp"}, MinInterval: 60}
+			},
+			fields: []string{"policy"},
+		},
+		{
+			name:   "policy removed",
+			change: func(r *models.ProblemRevision) { r.Policy = nil },
+			fields: []string{"policy"},
+		},
+		{
+			name:   "signature",
+			change: func(r *models.ProblemRevision) { r.Signature.Params[0].Type = "long" },
+			fields: []string{"signature"},
+		},
+		{
+			name: "harnesses",
+			change: func(r *models.ProblemRevision) {
+				r.Harnesses[0].Harness = "main2 {{solution}}"
+				r.Harnesses[1] = models.RevisionHarness{Language: "java", Harness: "{{solution}}"}
+			},
+			harnesses: []string{"cpp: harness", "java: added", "python: removed"},
+		},
+		{
+			name:       "statement",
+			change:     func(r *models.ProblemRevision) { r.Statements[0].Legend = "Sum" },
+			statements: []string{"en"},
+		},
+		{
+			name: "tests",
+			change: func(r *models.ProblemRevision) {
+				r.TestCases[1].Output = "5"
+				r.TestCases = append(r.TestCases, models.TestCase{Input: "3 3", Output: "6"})
+			},
+			added:   1,
+			changed: []int{1},
+		},
+		{
+			name:    "test removed",
+			change:  func(r *models.ProblemRevision) { r.TestCases = r.TestCases[:1] },
+			removed: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := base(), base()
+			to.Number = 2
+			tt.change(to)
+
+			diff := DiffRevisions(from, to)
+			if diff.From != 1 || diff.To != 2 {
+				t.Errorf("diff is from %d to %d", diff.From, diff.To)
+			}
+
+			var fields []string
+			for _, f := range diff.Fields {
+				fields = append(fields, f.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("fields = %v, want %v", fields, tt.fields)
+			}
+
+			var statements []string
+			for _, st := range diff.Statements {
+				statements = append(statements, st.Language)
+			}
+			if !reflect.DeepEqual(statements, tt.statements) {
+				t.Errorf("statements = %v, want %v", statements, tt.statements)
+			}
+
+			var harnesses []string
+			for _, h := range diff.Harnesses {
+				switch {
+				case h.From == nil:
+					harnesses = append(harnesses, h.Language+": added")
+				case h.To == nil:
+					harnesses = append(harnesses, h.Language+": removed")
+				default:
+					for _, section := range h.Sections {
+						harnesses = append(harnesses, h.Language+": "+section)
+					}
+				}
+			}
+			if !reflect.DeepEqual(harnesses, tt.harnesses) {
+				t.Errorf("harnesses = %v, want %v", harnesses, tt.harnesses)
+			}
+
+			var changed []int
+			for _, tc := range diff.TestsChanged {
+				changed = append(changed, tc.Index)
+			}
+			if len(diff.TestsAdded) != tt.added || len(diff.TestsRemoved) != tt.removed || !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("tests added %d, removed %d, changed %v, want %d, %d, %v",
+					len(diff.TestsAdded), len(diff.TestsRemoved), changed, tt.added, tt.removed, tt.changed)
+			}
+		})
+	}
+}
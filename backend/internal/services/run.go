@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/onlinejudge/backend/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxRunOutput truncates stdout and stderr returned to the user.
+const maxRunOutput = 64 << 10
+
+// customRunLimits bound a custom invocation, which has no problem to take
+// limits from. Programs writing more than is returned are stopped.
+var customRunLimits = RunLimits{
+	Time:   5 * time.Second,
+	Memory: 256,
+	Output: maxRunOutput,
+}
+
+// CustomRun is the outcome of running user code on user input.
+type CustomRun struct {
+	Status       string `json:"status"` // ok, compilation_error, runtime_error, time_limit, output_limit
+	Stdout       string `json:"stdout"`
+	Stderr       string `json:"stderr"`
+	ExitCode     int    `json:"exit_code"`
+	TimeUsed     int    `json:"time_used"`   // in milliseconds
+	MemoryUsed   int    `json:"memory_used"` // in KB
+	CompileError string `json:"compile_error,omitempty"`
+	Truncated    bool   `json:"truncated,omitempty"`
+}
+
+// RunCustom compiles and runs code once on stdin in the same sandbox used
+// for judging. Nothing is persisted. The compile and run are traced as
+// children of the span in ctx.
+func (e *Evaluator) RunCustom(ctx context.Context, language, code, stdin string) (*CustomRun, error) {
+	dir, err := os.MkdirTemp(e.workDir, "run_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create run directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	codeFile := filepath.Join(dir, getSourceFileName(language))
+	if err := os.WriteFile(codeFile, []byte(code), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write code file: %v", err)
+	}
+
+	_, span := tracing.Tracer().Start(ctx, "compile", trace.WithAttributes(attribute.String("submission.language", language)))
+	err = e.compile(language, codeFile)
+	if err != nil {
+		span.SetStatus(codes.Error, "compilation failed")
+	}
+	span.End()
+	if err != nil {
+		return &CustomRun{Status: "compilation_error", CompileError: err.Error()}, nil
+	}
+
+	cmd := runCommand(language, codeFile)
+	if cmd == nil {
+		return nil, fmt.Errorf("unsupported language: %s", language)
+	}
+
+	_, span = tracing.Tracer().Start(ctx, "run")
+	run := e.execute(cmd, stdin, customRunLimits)
+	span.End()
+	if run.StartError != nil {
+		return nil, fmt.Errorf("failed to run program: %v", run.StartError)
+	}
+
+	result := &CustomRun{
+		Status:     "ok",
+		ExitCode:   run.ExitCode,
+		TimeUsed:   run.TimeUsed,
+		MemoryUsed: run.MemoryUsed,
+	}
+	switch {
+	case run.TimedOut:
+		result.Status = "time_limit"
+	case run.OutputLimitExceeded:
+		result.Status = "output_limit"
+	case run.ExitCode != 0:
+		result.Status = "runtime_error"
+	}
+
+	var truncated bool
+	result.Stdout, truncated = truncateOutput(run.Stdout)
+	result.Truncated = truncated
+	result.Stderr, truncated = truncateOutput(run.Stderr)
+	result.Truncated = result.Truncated || truncated || run.OutputLimitExceeded
+
+	return result, nil
+}
+
+func truncateOutput(s string) (string, bool) {
+	if len(s) <= maxRunOutput {
+		return s, false
+	}
+	return strings.ToValidUTF8(s[:maxRunOutput], ""), true
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/pkg/database"
+	"github.com/onlinejudge/backend/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrNoSamples is returned when a sample run targets a problem without
+// sample tests.
+var ErrNoSamples = errors.New("problem has no sample tests")
+
+// RunSamples judges run against the sample tests of revision and fills in
+// its status and per-sample results. Unlike Evaluate it runs every sample
+// even after a failure, so the user sees all differences at once. Queries
+// use ctx and the compile and runs are traced as children of its span.
+func (e *Evaluator) RunSamples(ctx context.Context, run *models.SampleRun, revision *models.ProblemRevision) error {
+	var samples []models.TestCase
+	for _, tc := range revision.TestCases {
+		if tc.IsSample {
+			samples = append(samples, tc)
+		}
+	}
+	if len(samples) == 0 {
+		return ErrNoSamples
+	}
+
+	dir, err := os.MkdirTemp(e.workDir, "samples_")
+	if err != nil {
+		return fmt.Errorf("failed to create run directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	code, err := WrapSource(database.DB.WithContext(ctx), revision, run.Language, run.Code)
+	if err != nil {
+		return err
+	}
+
+	codeFile, err := writeSource(dir, run.Language, code, run.Files)
+	if err != nil {
+		return err
+	}
+
+	_, span := tracing.Tracer().Start(ctx, "compile", trace.WithAttributes(attribute.String("submission.language", run.Language)))
+	err = e.compile(run.Language, codeFile)
+	if err != nil {
+		span.SetStatus(codes.Error, "compilation failed")
+	}
+	span.End()
+	if err != nil {
+		run.Status = "compilation_error"
+		run.Error = err.Error()
+		return nil
+	}
+
+	run.Status = "accepted"
+	run.Results = make([]models.SampleResult, 0, len(samples))
+	for i, tc := range samples {
+		_, span := tracing.Tracer().Start(ctx, "run sample", trace.WithAttributes(
+			attribute.Int("test.number", i+1),
+			attribute.Int("test.id", int(tc.ID)),
+		))
+		result := e.runSample(run.Language, revision, tc, codeFile)
+		span.SetAttributes(attribute.String("test.status", result.Status))
+		span.End()
+		if result.Status != "accepted" && run.Status == "accepted" {
+			run.Status = result.Status
+		}
+		if result.TimeUsed > run.TimeUsed {
+			run.TimeUsed = result.TimeUsed
+		}
+		if result.MemoryUsed > run.MemoryUsed {
+			run.MemoryUsed = result.MemoryUsed
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	return nil
+}
+
+func (e *Evaluator) runSample(language string, revision *models.ProblemRevision, tc models.TestCase, codeFile string) models.SampleResult {
+	result := models.SampleResult{
+		TestCaseID: tc.ID,
+		Input:      tc.Input,
+		Expected:   tc.Output,
+	}
+
+	cmd := runCommand(language, codeFile)
+	if cmd == nil {
+		result.Status = "runtime_error"
+		result.Stderr = "Unsupported language"
+		return result
+	}
+
+	run := e.runWithIO(cmd, revision, tc)
+	result.Actual, _ = truncateOutput(run.Stdout)
+	result.Stderr, _ = truncateOutput(run.Stderr)
+	result.TimeUsed = run.TimeUsed
+	result.MemoryUsed = run.MemoryUsed
+
+	switch {
+	case run.StartError != nil:
+		result.Status = "runtime_error"
+		result.Stderr = run.StartError.Error()
+	case run.TimedOut:
+		result.Status = "time_limit"
+	case revision.MemoryLimit > 0 && run.MemoryUsed > revision.MemoryLimit<<10:
+		result.Status = "memory_limit"
+	case run.OutputLimitExceeded:
+		result.Status = "output_limit"
+	case run.ExitCode != 0:
+		result.Status = "runtime_error"
+	case !checkOutput(revision.Checker, run.Stdout, tc.Output):
+		result.Status = "wrong_answer"
+		result.FirstDiffLine = firstDiffLine(run.Stdout, tc.Output)
+	default:
+		result.Status = "accepted"
+	}
+
+	return result
+}
+
+// firstDiffLine returns the 1-based number of the first line where output
+// and expected differ, or 0 if they are identical.
+func firstDiffLine(output, expected string) int {
+	got, want := strings.Split(output, "\n"), strings.Split(expected, "\n")
+	for i := 0; i < len(got) || i < len(want); i++ {
+		if i >= len(got) || i >= len(want) || got[i] != want[i] {
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort")
+	ErrInvalidStatus = errors.New("invalid status filter")
+)
+
+// problemSorts maps the sort names accepted by SearchProblems to the SQL
+// expression they order by. Ties are always broken by problem ID.
+var problemSorts = map[string]string{
+	"newest":     "problems.id",
+	"points":     "problems.points",
+	"solves":     "COALESCE(stats.solvers, 0)",
+	"acceptance": "COALESCE(stats.acceptance_rate, 0)",
+}
+
+// problemStatsJoin attaches the incrementally maintained aggregates used by
+// the statistics-based sorts.
+const problemStatsJoin = "LEFT JOIN problem_stats AS stats ON stats.problem_id = problems.id"
+
+// problemSearchVector and statementSearchVector must match the expression
+// indexes created in database.InitDB for full-text search to use them.
+const (
+	problemSearchVector   = "to_tsvector('simple', problems.title || ' ' || problems.description)"
+	statementSearchVector = "to_tsvector('simple', ps.title || ' ' || ps.legend || ' ' || ps.input_format || ' ' || ps.output_format || ' ' || ps.notes)"
+)
+
+type ProblemFilter struct {
+	Query        string   // full-text search over titles and statements
+	Difficulty   string   // exact match
+	Tags         []string // tag names
+	MatchAllTags bool     // require every tag instead of any
+	MinPoints    *int
+	MaxPoints    *int
+	Author       string // username of the problem's creator
+	Status       string // solved, unsolved or attempted by UserID
+	UserID       uint
+	Sort         string // see problemSorts, defaults to newest
+	Ascending    bool
+	Cursor       string // NextCursor of the previous page
+	Limit        int
+}
+
+type ProblemPage struct {
+	Problems   []models.Problem `json:"problems"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	Total      int64            `json:"total"`
+	Limit      int              `json:"limit"`
+}
+
+// problemCursor is the position after the last problem of a page.
+type problemCursor struct {
+	Value float64 `json:"v"`
+	ID    uint    `json:"id"`
+}
+
+func (c problemCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeProblemCursor(s string) (*problemCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c problemCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// SearchProblems returns one page of problems matching f using keyset
+// pagination, so deep pages cost the same as the first one.
+func SearchProblems(db *gorm.DB, f ProblemFilter) (*ProblemPage, error) {
+	if f.Sort == "" {
+		f.Sort = "newest"
+	}
+	sortExpr, ok := problemSorts[f.Sort]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+	if f.Limit <= 0 || f.Limit > 100 {
+		f.Limit = 20
+	}
+
+	query := db.Model(&models.Problem{})
+
+	if f.Query != "" {
+		query = query.Where("("+problemSearchVector+" @@ plainto_tsquery('simple', ?) OR EXISTS ("+
+			"SELECT 1 FROM problem_statements ps WHERE ps.revision_id = problems.revision_id AND "+
+			statementSearchVector+" @@ plainto_tsquery('simple', ?)))", f.Query, f.Query)
+	}
+
+	if f.Difficulty != "" {
+		query = query.Where("problems.difficulty = ?", f.Difficulty)
+	}
+
+	if len(f.Tags) > 0 {
+		tagged := db.Table("problem_tags").
+			Select("problem_tags.problem_id").
+			Joins("JOIN tags ON tags.id = problem_tags.tag_id").
+			Where("tags.name IN ?", f.Tags)
+		if f.MatchAllTags {
+			tagged = tagged.Group("problem_tags.problem_id").
+				Having("COUNT(DISTINCT tags.name) = ?", len(f.Tags))
+		}
+		query = query.Where("problems.id IN (?)", tagged)
+	}
+
+	if f.MinPoints != nil {
+		query = query.Where("problems.points >= ?", *f.MinPoints)
+	}
+	if f.MaxPoints != nil {
+		query = query.Where("problems.points <= ?", *f.MaxPoints)
+	}
+
+	if f.Author != "" {
+		query = query.Where("problems.created_by IN (?)",
+			db.Table("users").Select("id").Where("username = ?", f.Author))
+	}
+
+	if f.Status != "" {
+		solved := db.Table("submissions").Select("problem_id").
+			Where("user_id = ? AND status = ?", f.UserID, "accepted")
+		attempted := db.Table("submissions").Select("problem_id").
+			Where("user_id = ?", f.UserID)
+
+		switch f.Status {
+		case "solved":
+			query = query.Where("problems.id IN (?)", solved)
+		case "unsolved":
+			query = query.Where("problems.id NOT IN (?)", solved)
+		case "attempted":
+			query = query.Where("problems.id IN (?) AND problems.id NOT IN (?)", attempted, solved)
+		default:
+			return nil, ErrInvalidStatus
+		}
+	}
+
+	page := &ProblemPage{Problems: []models.Problem{}, Limit: f.Limit}
+	if err := query.Session(&gorm.Session{}).Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	if f.Sort == "solves" || f.Sort == "acceptance" {
+		query = query.Joins(problemStatsJoin)
+	}
+
+	direction, cmp := "DESC", "<"
+	if f.Ascending {
+		direction, cmp = "ASC", ">"
+	}
+
+	if f.Cursor != "" {
+		cursor, err := decodeProblemCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(fmt.Sprintf("((%[1]s %[2]s ?) OR (%[1]s = ? AND problems.id %[2]s ?))", sortExpr, cmp),
+			cursor.Value, cursor.Value, cursor.ID)
+	}
+
+	// Fetch one extra key to learn whether another page follows
+	var keys []struct {
+		ID        uint
+		SortValue float64
+	}
+	if err := query.Select(fmt.Sprintf("problems.id AS id, %s AS sort_value", sortExpr)).
+		Order(fmt.Sprintf("%s %s, problems.id %s", sortExpr, direction, direction)).
+		Limit(f.Limit + 1).
+		Scan(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	if len(keys) > f.Limit {
+		last := keys[f.Limit-1]
+		page.NextCursor = problemCursor{Value: last.SortValue, ID: last.ID}.encode()
+		keys = keys[:f.Limit]
+	}
+	if len(keys) == 0 {
+		return page, nil
+	}
+
+	ids := make([]uint, len(keys))
+	for i, k := range keys {
+		ids[i] = k.ID
+	}
+
+	var problems []models.Problem
+	if err := db.Preload("Tags").Where("id IN ?", ids).Find(&problems).Error; err != nil {
+		return nil, err
+	}
+
+	// Restore the keyset order lost by the IN lookup
+	byID := make(map[uint]models.Problem, len(problems))
+	for _, p := range problems {
+		byID[p.ID] = p
+	}
+	for _, id := range ids {
+		if p, ok := byID[id]; ok {
+			page.Problems = append(page.Problems, p)
+		}
+	}
+
+	return page, nil
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+)
+
+// TokenPair is what a successful login or refresh returns to the client.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // access token lifetime in seconds
+}
+
+// AccessClaims identify the user and session an access token was issued for.
+type AccessClaims struct {
+	UserID    uint `json:"user_id"`
+	SessionID uint `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+func jwtSecret() []byte {
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSecretToken returns a random URL-safe token for refresh and API tokens.
+func newSecretToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func issueAccessToken(session *models.Session) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, AccessClaims{
+		UserID:    session.UserID,
+		SessionID: session.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+		},
+	})
+	return token.SignedString(jwtSecret())
+}
+
+// ParseAccessToken verifies an access token and returns its claims.
+func ParseAccessToken(tokenString string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid || claims.SessionID == 0 {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// StartSession opens a new session for user and returns its first tokens.
+func StartSession(db *gorm.DB, user *models.User, userAgent, ip string) (*TokenPair, error) {
+	refresh, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: hashToken(refresh),
+		UserAgent:        userAgent,
+		IP:               ip,
+		ExpiresAt:        now.Add(RefreshTokenTTL),
+		LastUsedAt:       now,
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+
+	return tokenPair(&session, refresh)
+}
+
+// RefreshSession exchanges a refresh token for new tokens, rotating the
+// refresh token. Presenting a token that was already rotated out means it
+// leaked, so the whole session is revoked.
+func RefreshSession(db *gorm.DB, refreshToken string) (*TokenPair, error) {
+	hash := hashToken(refreshToken)
+	now := time.Now()
+
+	var session models.Session
+	if err := db.Where("refresh_token_hash = ?", hash).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if db.Where("previous_token_hash = ?", hash).First(&session).Error == nil {
+				RevokeSession(db, session.UserID, session.ID)
+				return nil, ErrSessionRevoked
+			}
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	if !session.Active(now) {
+		return nil, ErrSessionRevoked
+	}
+
+	refresh, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// Rotate only if nobody else rotated the token in the meantime
+	result := db.Model(&session).
+		Where("refresh_token_hash = ?", hash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":  hashToken(refresh),
+			"previous_token_hash": hash,
+			"last_used_at":        now,
+			"expires_at":          now.Add(RefreshTokenTTL),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return tokenPair(&session, refresh)
+}
+
+func tokenPair(session *models.Session, refresh string) (*TokenPair, error) {
+	access, err := issueAccessToken(session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign access token: %v", err)
+	}
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// SessionActive reports whether a session of the user exists and has not
+// been revoked or expired.
+func SessionActive(db *gorm.DB, userID, sessionID uint) bool {
+	var session models.Session
+	if err := db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		return false
+	}
+	return session.Active(time.Now())
+}
+
+// RevokeSession revokes one session of a user.
+func RevokeSession(db *gorm.DB, userID, sessionID uint) error {
+	result := db.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes every session of a user, for logging out
+// everywhere.
+func RevokeUserSessions(db *gorm.DB, userID uint) error {
+	return db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserAccess revokes every session and personal access token of a
+// user, for when their password is reset or their account is deleted or
+// changed by an admin, so that nobody keeps access the reset was meant to
+// take away.
+func RevokeUserAccess(db *gorm.DB, userID uint) error {
+	if err := RevokeUserSessions(db, userID); err != nil {
+		return err
+	}
+	return RevokeUserAPITokens(db, userID)
+}
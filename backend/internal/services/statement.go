@@ -0,0 +1,73 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/onlinejudge/backend/internal/models"
+)
+
+// ValidateStatement checks that a translation names its language and title
+// and that every Markdown section closes the math it opens, so KaTeX on the
+// client never swallows the rest of a section.
+func ValidateStatement(st *models.ProblemStatement) error {
+	if st.Language == "" {
+		return fmt.Errorf("statement language is required")
+	}
+	if st.Title == "" {
+		return fmt.Errorf("statement %q: title is required", st.Language)
+	}
+
+	for _, section := range []struct {
+		name, text string
+	}{
+		{"legend", st.Legend},
+		{"input_format", st.InputFormat},
+		{"output_format", st.OutputFormat},
+		{"notes", st.Notes},
+		{"scoring", st.Scoring},
+	} {
+		if err := checkMath(section.text); err != nil {
+			return fmt.Errorf("statement %q, %s: %v", st.Language, section.name, err)
+		}
+	}
+
+	return nil
+}
+
+// checkMath reports an unterminated $...$ or $$...$$ span. Escaped dollars
+// and dollars inside code spans or fenced code blocks are ignored.
+func checkMath(text string) error {
+	inline, display, code, fence := false, false, false, false
+
+	for i := 0; i < len(text); i++ {
+		switch ch := text[i]; {
+		case ch == '\\':
+			i++ // skip the escaped character
+		case strings.HasPrefix(text[i:], "```"):
+			if !inline && !display {
+				fence = !fence
+			}
+			i += 2
+		case ch == '`' && !fence:
+			if !inline && !display {
+				code = !code
+			}
+		case ch == '$' && !code && !fence:
+			if strings.HasPrefix(text[i:], "$$") && !inline {
+				display = !display
+				i++
+			} else if !display {
+				inline = !inline
+			}
+		}
+	}
+
+	switch {
+	case inline:
+		return fmt.Errorf("unterminated inline math")
+	case display:
+		return fmt.Errorf("unterminated display math")
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"sort"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Histogram bucket lower bounds for accepted runs.
+var (
+	timeBuckets   = []int{0, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}        // in milliseconds
+	memoryBuckets = []int{0, 1024, 4096, 16384, 65536, 131072, 262144, 524288, 1048576} // in KB
+)
+
+type HistogramBucket struct {
+	From  int `json:"from"`
+	Count int `json:"count"`
+}
+
+type LanguageStats struct {
+	Language        string            `json:"language"`
+	Accepted        int               `json:"accepted"`
+	AvgTime         float64           `json:"avg_time"`   // in milliseconds
+	AvgMemory       float64           `json:"avg_memory"` // in KB
+	TimeHistogram   []HistogramBucket `json:"time_histogram"`
+	MemoryHistogram []HistogramBucket `json:"memory_histogram"`
+}
+
+type ProblemStatsReport struct {
+	models.ProblemStats
+	Verdicts  map[string]int  `json:"verdicts"`
+	Languages []LanguageStats `json:"languages"`
+}
+
+// bucketFor returns the lower bound of the bucket v falls into.
+func bucketFor(bounds []int, v int) int {
+	i := sort.SearchInts(bounds, v+1) - 1
+	if i < 0 {
+		i = 0
+	}
+	return bounds[i]
+}
+
+// RecordVerdict adds a judged submission to its problem's statistics.
+func RecordVerdict(tx *gorm.DB, submission *models.Submission) error {
+	return applyVerdict(tx, submission, 1)
+}
+
+// ForgetVerdict removes a previously recorded verdict, before the submission
+// is rejudged. It must run before the submission's status is reset.
+func ForgetVerdict(tx *gorm.DB, submission *models.Submission) error {
+	return applyVerdict(tx, submission, -1)
+}
+
+func applyVerdict(tx *gorm.DB, s *models.Submission, delta int) error {
+	if s.Status == "" || s.Status == "pending" {
+		return nil
+	}
+
+	accepted := s.Status == "accepted"
+	acceptedDelta, solverDelta := 0, 0
+	if accepted {
+		acceptedDelta = delta
+
+		// Concurrent verdicts for the problem wait for the statistics row
+		// lock, so two accepted submissions of one user judged at the same
+		// time cannot each miss the other and both count a new solver
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.ProblemStats{ProblemID: s.ProblemID}).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("problem_id").
+			Take(&models.ProblemStats{}, "problem_id = ?", s.ProblemID).Error; err != nil {
+			return err
+		}
+
+		// The user becomes (or stops being) a solver only through their
+		// first (or last) accepted submission
+		var others int64
+		if err := tx.Model(&models.Submission{}).
+			Where("problem_id = ? AND user_id = ? AND status = ? AND id <> ?", s.ProblemID, s.UserID, "accepted", s.ID).
+			Count(&others).Error; err != nil {
+			return err
+		}
+		if others == 0 {
+			solverDelta = delta
+		}
+	}
+
+	if err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "problem_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"submissions": gorm.Expr("problem_stats.submissions + ?", delta),
+			"accepted":    gorm.Expr("problem_stats.accepted + ?", acceptedDelta),
+			"solvers":     gorm.Expr("problem_stats.solvers + ?", solverDelta),
+		}),
+	}).Create(&models.ProblemStats{
+		ProblemID:   s.ProblemID,
+		Submissions: delta,
+		Accepted:    acceptedDelta,
+		Solvers:     solverDelta,
+	}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&models.ProblemStats{}).Where("problem_id = ?", s.ProblemID).
+		Update("acceptance_rate", gorm.Expr("CASE WHEN submissions > 0 THEN CAST(accepted AS FLOAT) / submissions ELSE 0 END")).
+		Error; err != nil {
+		return err
+	}
+
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "problem_id"}, {Name: "status"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("problem_verdict_counts.count + ?", delta)}),
+	}).Create(&models.ProblemVerdictCount{
+		ProblemID: s.ProblemID,
+		Status:    s.Status,
+		Count:     delta,
+	}).Error; err != nil {
+		return err
+	}
+
+	if !accepted {
+		return nil
+	}
+
+	if err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "problem_id"}, {Name: "language"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"accepted":     gorm.Expr("problem_language_stats.accepted + ?", delta),
+			"total_time":   gorm.Expr("problem_language_stats.total_time + ?", delta*s.TimeUsed),
+			"total_memory": gorm.Expr("problem_language_stats.total_memory + ?", delta*s.MemoryUsed),
+		}),
+	}).Create(&models.ProblemLanguageStats{
+		ProblemID:   s.ProblemID,
+		Language:    s.Language,
+		Accepted:    delta,
+		TotalTime:   int64(delta * s.TimeUsed),
+		TotalMemory: int64(delta * s.MemoryUsed),
+	}).Error; err != nil {
+		return err
+	}
+
+	for _, b := range []models.ProblemRunBucket{
+		{ProblemID: s.ProblemID, Language: s.Language, Metric: "time", Bucket: bucketFor(timeBuckets, s.TimeUsed), Count: delta},
+		{ProblemID: s.ProblemID, Language: s.Language, Metric: "memory", Bucket: bucketFor(memoryBuckets, s.MemoryUsed), Count: delta},
+	} {
+		bucket := b
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "problem_id"}, {Name: "language"}, {Name: "metric"}, {Name: "bucket"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("problem_run_buckets.count + ?", delta)}),
+		}).Create(&bucket).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RebuildProblemStats recomputes a problem's statistics from its submissions,
+// for problems judged before statistics were kept or after manual edits.
+func RebuildProblemStats(db *gorm.DB, problemID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{
+			&models.ProblemStats{},
+			&models.ProblemVerdictCount{},
+			&models.ProblemLanguageStats{},
+			&models.ProblemRunBucket{},
+		} {
+			if err := tx.Where("problem_id = ?", problemID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		var submissions []models.Submission
+		if err := tx.Where("problem_id = ? AND status <> ?", problemID, "pending").
+			Order("id").Find(&submissions).Error; err != nil {
+			return err
+		}
+
+		solvers := make(map[uint]bool)
+		for i := range submissions {
+			if err := applyVerdict(tx, &submissions[i], 1); err != nil {
+				return err
+			}
+			if submissions[i].Status == "accepted" {
+				solvers[submissions[i].UserID] = true
+			}
+		}
+
+		// applyVerdict sees every submission already stored and so cannot
+		// tell first solves apart during a replay; count solvers directly
+		return tx.Model(&models.ProblemStats{}).Where("problem_id = ?", problemID).
+			Update("solvers", len(solvers)).Error
+	})
+}
+
+// GetProblemStats assembles the statistics report for a problem.
+func GetProblemStats(db *gorm.DB, problemID uint) (*ProblemStatsReport, error) {
+	report := &ProblemStatsReport{
+		ProblemStats: models.ProblemStats{ProblemID: problemID},
+		Verdicts:     map[string]int{},
+		Languages:    []LanguageStats{},
+	}
+
+	if err := db.Where("problem_id = ?", problemID).Limit(1).Find(&report.ProblemStats).Error; err != nil {
+		return nil, err
+	}
+
+	var verdicts []models.ProblemVerdictCount
+	if err := db.Where("problem_id = ? AND count > 0", problemID).Find(&verdicts).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range verdicts {
+		report.Verdicts[v.Status] = v.Count
+	}
+
+	var languages []models.ProblemLanguageStats
+	if err := db.Where("problem_id = ? AND accepted > 0", problemID).Order("language").Find(&languages).Error; err != nil {
+		return nil, err
+	}
+
+	var buckets []models.ProblemRunBucket
+	if err := db.Where("problem_id = ? AND count > 0", problemID).Order("bucket").Find(&buckets).Error; err != nil {
+		return nil, err
+	}
+
+	for _, l := range languages {
+		stats := LanguageStats{
+			Language:        l.Language,
+			Accepted:        l.Accepted,
+			AvgTime:         float64(l.TotalTime) / float64(l.Accepted),
+			AvgMemory:       float64(l.TotalMemory) / float64(l.Accepted),
+			TimeHistogram:   []HistogramBucket{},
+			MemoryHistogram: []HistogramBucket{},
+		}
+		for _, b := range buckets {
+			if b.Language != l.Language {
+				continue
+			}
+			if b.Metric == "time" {
+				stats.TimeHistogram = append(stats.TimeHistogram, HistogramBucket{From: b.Bucket, Count: b.Count})
+			} else {
+				stats.MemoryHistogram = append(stats.MemoryHistogram, HistogramBucket{From: b.Bucket, Count: b.Count})
+			}
+		}
+		report.Languages = append(report.Languages, stats)
+	}
+
+	return report, nil
+}
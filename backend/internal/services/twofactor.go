@@ -0,0 +1,318 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 // accepted steps before and after the current one
+
+	RecoveryCodeCount = 10
+
+	LoginChallengeTTL         = 5 * time.Minute
+	loginChallengeMaxAttempts = 5
+)
+
+var (
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorRequired    = errors.New("two-factor authentication is required for this role")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	ErrInvalidChallenge     = errors.New("login challenge expired or is invalid")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactorRequired reports whether role may only use its permissions with
+// two-factor authentication enabled. TWO_FACTOR_REQUIRED_ROLES lists the
+// roles, comma separated; the default is admin.
+func TwoFactorRequired(role string) bool {
+	roles := os.Getenv("TWO_FACTOR_REQUIRED_ROLES")
+	if roles == "" {
+		roles = RoleAdmin
+	}
+	for _, r := range strings.Split(roles, ",") {
+		if strings.TrimSpace(r) == role {
+			return true
+		}
+	}
+	return false
+}
+
+// TwoFactorEnabled reports whether the user finished 2FA enrollment.
+func TwoFactorEnabled(db *gorm.DB, userID uint) bool {
+	var count int64
+	db.Model(&models.TwoFactor{}).Where("user_id = ? AND enabled_at IS NOT NULL", userID).Count(&count)
+	return count > 0
+}
+
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTOTP returns the time step code is valid for at t, or 0.
+func matchTOTP(secret, code string, t time.Time) int64 {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0
+	}
+	code = strings.ReplaceAll(code, " ", "")
+
+	current := t.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return 0
+}
+
+// useTOTP accepts a code for the user's secret once; a code seen before is
+// rejected even within its validity window.
+func useTOTP(db *gorm.DB, tf *models.TwoFactor, code string) bool {
+	step := matchTOTP(tf.Secret, code, time.Now())
+	if step == 0 || step <= tf.LastUsedStep {
+		return false
+	}
+
+	result := db.Model(&models.TwoFactor{}).
+		Where("id = ? AND last_used_step < ?", tf.ID, step).
+		Update("last_used_step", step)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	tf.LastUsedStep = step
+	return true
+}
+
+// useRecoveryCode spends one of the user's unused recovery codes.
+func useRecoveryCode(db *gorm.DB, userID uint, code string) bool {
+	code = strings.NewReplacer(" ", "", "-", "").Replace(strings.ToLower(code))
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(code)).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected > 0
+}
+
+// TwoFactorEnrollment is what the user adds to their authenticator app.
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+}
+
+// BeginTwoFactor creates a new TOTP secret for user, replacing an earlier
+// enrollment that was never confirmed.
+func BeginTwoFactor(db *gorm.DB, user *models.User) (*TwoFactorEnrollment, error) {
+	if TwoFactorEnabled(db, user.ID) {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	secret := totpEncoding.EncodeToString(key)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.TwoFactor{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.TwoFactor{UserID: user.ID, Secret: secret}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "Online Judge"
+	}
+	otpauth := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + user.Username,
+		RawQuery: url.Values{
+			"secret": {secret},
+			"issuer": {issuer},
+		}.Encode(),
+	}
+
+	return &TwoFactorEnrollment{Secret: secret, OTPAuthURL: otpauth.String()}, nil
+}
+
+// ConfirmTwoFactor enables 2FA once the user enters a code from the new
+// secret, and returns their first set of recovery codes.
+func ConfirmTwoFactor(db *gorm.DB, user *models.User, code string) ([]string, error) {
+	var tf models.TwoFactor
+	if err := db.Where("user_id = ?", user.ID).First(&tf).Error; err != nil {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if tf.EnabledAt != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+	if !useTOTP(db, &tf, code) {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	if err := db.Model(&tf).Update("enabled_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+	return newRecoveryCodes(db, user.ID)
+}
+
+// checkTwoFactorCode accepts a TOTP code or a recovery code for the user.
+func checkTwoFactorCode(db *gorm.DB, userID uint, code string) error {
+	var tf models.TwoFactor
+	if err := db.Where("user_id = ? AND enabled_at IS NOT NULL", userID).First(&tf).Error; err != nil {
+		return ErrTwoFactorNotEnabled
+	}
+	if useTOTP(db, &tf, code) || useRecoveryCode(db, userID, code) {
+		return nil
+	}
+	return ErrInvalidTwoFactorCode
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking
+// a current code.
+func RegenerateRecoveryCodes(db *gorm.DB, user *models.User, code string) ([]string, error) {
+	if err := checkTwoFactorCode(db, user.ID, code); err != nil {
+		return nil, err
+	}
+	return newRecoveryCodes(db, user.ID)
+}
+
+// DisableTwoFactor turns 2FA off after checking a current code. Roles that
+// require 2FA cannot turn it off.
+func DisableTwoFactor(db *gorm.DB, user *models.User, code string) error {
+	if TwoFactorRequired(user.Role) {
+		return ErrTwoFactorRequired
+	}
+	if err := checkTwoFactorCode(db, user.ID, code); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.TwoFactor{}).Error
+	})
+}
+
+// RecoveryCodesLeft counts the user's unused recovery codes.
+func RecoveryCodesLeft(db *gorm.DB, userID uint) int64 {
+	var count int64
+	db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count)
+	return count
+}
+
+func newRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+	codes := make([]string, RecoveryCodeCount)
+	rows := make([]models.RecoveryCode, RecoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 10)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		for j := range buf {
+			buf[j] = alphabet[int(buf[j])%len(alphabet)]
+		}
+		codes[i] = string(buf[:5]) + "-" + string(buf[5:])
+		rows[i] = models.RecoveryCode{UserID: userID, CodeHash: hashToken(string(buf))}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// StartLoginChallenge records that user got their password right and
+// returns the token the second login step is made with.
+func StartLoginChallenge(db *gorm.DB, user *models.User) (string, error) {
+	token, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	db.Where("expires_at < ?", now).Delete(&models.LoginChallenge{})
+	if err := db.Create(&models.LoginChallenge{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(LoginChallengeTTL),
+	}).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// CompleteLoginChallenge checks the second login step and returns the user
+// it logs in. A challenge allows a few wrong codes and then stops working.
+func CompleteLoginChallenge(db *gorm.DB, token, code string) (*models.User, error) {
+	var challenge models.LoginChallenge
+	if err := db.Where("token_hash = ?", hashToken(token)).First(&challenge).Error; err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	if time.Now().After(challenge.ExpiresAt) {
+		db.Delete(&challenge)
+		return nil, ErrInvalidChallenge
+	}
+
+	// Count the attempt before checking, so parallel guesses cannot exceed the limit
+	result := db.Model(&models.LoginChallenge{}).
+		Where("id = ? AND attempts < ?", challenge.ID, loginChallengeMaxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		db.Delete(&challenge)
+		return nil, ErrInvalidChallenge
+	}
+
+	if err := checkTwoFactorCode(db, challenge.UserID, code); err != nil {
+		return nil, err
+	}
+	db.Delete(&challenge)
+
+	var user models.User
+	if err := db.First(&user, challenge.UserID).Error; err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	return &user, nil
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors.
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1; the six-digit codes are the last six of
+	// the eight-digit ones listed there
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	secret := totpEncoding.EncodeToString(rfc6238Secret)
+	for _, tt := range tests {
+		step := tt.unix / totpPeriod
+		if got := totpCode(rfc6238Secret, step); got != tt.code {
+			t.Errorf("totpCode(T=%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+		if got := matchTOTP(secret, tt.code, time.Unix(tt.unix, 0)); got != step {
+			t.Errorf("matchTOTP(%s) at %d = step %d, want %d", tt.code, tt.unix, got, step)
+		}
+	}
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Secret)
+	issued := time.Unix(1111111111, 0)
+	code := totpCode(rfc6238Secret, issued.Unix()/totpPeriod)
+
+	tests := []struct {
+		name  string
+		at    time.Time
+		valid bool
+	}{
+		{"same step", issued, true},
+		{"one step later", issued.Add(totpPeriod * time.Second), true},
+		{"one step earlier", issued.Add(-totpPeriod * time.Second), true},
+		{"two steps later", issued.Add(2 * totpPeriod * time.Second), false},
+		{"two steps earlier", issued.Add(-2 * totpPeriod * time.Second), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if valid := matchTOTP(secret, code, tt.at) != 0; valid != tt.valid {
+				t.Errorf("matchTOTP() valid = %v, want %v", valid, tt.valid)
+			}
+		})
+	}
+
+	if matchTOTP(secret, code[:3]+" "+code[3:], issued) == 0 {
+		t.Error("matchTOTP() rejected a code with a space")
+	}
+	wrong := []byte(code)
+	wrong[0] = '0' + (wrong[0]-'0'+1)%10
+	if matchTOTP(secret, string(wrong), issued) != 0 {
+		t.Error("matchTOTP() accepted a wrong code")
+	}
+	if matchTOTP("not base32!", code, issued) != 0 {
+		t.Error("matchTOTP() accepted a code for an invalid secret")
+	}
+}
+
+// enableTwoFactor enrolls user and returns their secret, the code the
+// enrollment was confirmed with and their recovery codes.
+func enableTwoFactor(t *testing.T, db *gorm.DB, user *models.User) ([]byte, string, []string) {
+	t.Helper()
+	enrollment, err := BeginTwoFactor(db, user)
+	if err != nil {
+		t.Fatalf("BeginTwoFactor() = %v", err)
+	}
+	key, err := totpEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmed := currentCode(key)
+	codes, err := ConfirmTwoFactor(db, user, confirmed)
+	if err != nil {
+		t.Fatalf("ConfirmTwoFactor() = %v", err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), RecoveryCodeCount)
+	}
+	return key, confirmed, codes
+}
+
+func currentCode(key []byte) string {
+	return totpCode(key, time.Now().Unix()/totpPeriod)
+}
+
+func newTwoFactorTestDB(t *testing.T) *gorm.DB {
+	return newTestDB(t, &models.User{}, &models.TwoFactor{}, &models.RecoveryCode{})
+}
+
+func TestTwoFactorCodeReplay(t *testing.T) {
+	db := newTwoFactorTestDB(t)
+	user := createTestUser(t, db, "alice")
+	key, confirmed, _ := enableTwoFactor(t, db, user)
+
+	// The code used to confirm the enrollment cannot be used again
+	if err := checkTwoFactorCode(db, user.ID, confirmed); err != ErrInvalidTwoFactorCode {
+		t.Fatalf("reused code error = %v, want %v", err, ErrInvalidTwoFactorCode)
+	}
+
+	// Nor can a code of an earlier step once a later one was accepted
+	step := time.Now().Unix()/totpPeriod + 1
+	if err := checkTwoFactorCode(db, user.ID, totpCode(key, step)); err != nil {
+		t.Fatalf("code of the next step error = %v", err)
+	}
+	if err := checkTwoFactorCode(db, user.ID, totpCode(key, step-1)); err != ErrInvalidTwoFactorCode {
+		t.Errorf("code of an earlier step error = %v, want %v", err, ErrInvalidTwoFactorCode)
+	}
+}
+
+func TestRecoveryCodeIsSingleUse(t *testing.T) {
+	db := newTwoFactorTestDB(t)
+	user := createTestUser(t, db, "alice")
+	_, _, codes := enableTwoFactor(t, db, user)
+
+	// Codes are accepted regardless of case, spaces and the dash
+	if err := checkTwoFactorCode(db, user.ID, " "+strings.ToUpper(codes[0])+" "); err != nil {
+		t.Fatalf("recovery code error = %v", err)
+	}
+	if left := RecoveryCodesLeft(db, user.ID); left != RecoveryCodeCount-1 {
+		t.Errorf("%d recovery codes left, want %d", left, RecoveryCodeCount-1)
+	}
+	if err := checkTwoFactorCode(db, user.ID, codes[0]); err != ErrInvalidTwoFactorCode {
+		t.Errorf("used recovery code error = %v, want %v", err, ErrInvalidTwoFactorCode)
+	}
+	if err := checkTwoFactorCode(db, user.ID, strings.ReplaceAll(codes[1], "-", "")); err != nil {
+		t.Errorf("another recovery code error = %v", err)
+	}
+
+	// Another user's codes are not accepted
+	other := createTestUser(t, db, "bob")
+	enableTwoFactor(t, db, other)
+	if err := checkTwoFactorCode(db, other.ID, codes[2]); err != ErrInvalidTwoFactorCode {
+		t.Errorf("recovery code of another user error = %v, want %v", err, ErrInvalidTwoFactorCode)
+	}
+}
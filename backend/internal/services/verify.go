@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+
+	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/pkg/database"
+	"github.com/onlinejudge/backend/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// RequestVerification marks revision as pending verification and reports
+// whether there is anything to verify, that is whether the problem has
+// reference solutions. The caller then queues the revision for the judge,
+// which runs PrepareRevision on it.
+func RequestVerification(db *gorm.DB, revision *models.ProblemRevision) (bool, error) {
+	var count int64
+	if err := db.Model(&models.ProblemProgram{}).
+		Where("problem_id = ? AND kind = ?", revision.ProblemID, models.ProgramSolution).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count == 0 {
+		return false, nil
+	}
+
+	revision.VerificationStatus = "pending"
+	return true, db.Model(revision).Update("verification_status", "pending").Error
+}
+
+// PrepareRevision does the judge's work on a queued revision: it completes
+// tests that are still generating and then verifies the reference solutions
+// if verification is pending. Revisions are queued once per save or request,
+// so a verification already finished by an earlier message is not repeated.
+func (e *Evaluator) PrepareRevision(ctx context.Context, revisionID uint) error {
+	revision, err := e.PrepareTests(ctx, revisionID)
+	if err != nil {
+		return err
+	}
+	if revision.VerificationStatus != "pending" {
+		return nil
+	}
+
+	db := database.DB.WithContext(ctx)
+	if revision.TestStatus != models.TestsReady {
+		return db.Model(revision).Update("verification_status", "error").Error
+	}
+	if err := e.VerifySolutions(ctx, revision.ID); err != nil {
+		db.Model(revision).Update("verification_status", "error")
+		return err
+	}
+	return nil
+}
+
+// VerifySolutions runs every reference solution of the problem against the
+// tests of a revision, records a SolutionCheck for each and suggests a time
+// limit from the main solution's slowest test. Queries use ctx and each
+// solution is traced as a child of the span in ctx.
+func (e *Evaluator) VerifySolutions(ctx context.Context, revisionID uint) error {
+	db := database.DB.WithContext(ctx)
+
+	var revision models.ProblemRevision
+	if err := db.Preload("TestCases", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).First(&revision, revisionID).Error; err != nil {
+		return err
+	}
+
+	var solutions []models.ProblemProgram
+	if err := db.Where("problem_id = ? AND kind = ?", revision.ProblemID, models.ProgramSolution).
+		Order("id").Find(&solutions).Error; err != nil {
+		return err
+	}
+
+	status := "passed"
+	suggested := 0
+	checks := make([]models.SolutionCheck, 0, len(solutions))
+	for i := range solutions {
+		check := e.checkSolution(ctx, &revision, &solutions[i])
+		if !check.Matches {
+			status = "failed"
+		}
+		if check.Expected == models.ExpectMain {
+			suggested = suggestTimeLimit(check.MaxTime)
+		}
+		checks = append(checks, check)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("revision_id = ?", revision.ID).Delete(&models.SolutionCheck{}).Error; err != nil {
+			return err
+		}
+		if len(checks) > 0 {
+			if err := tx.Create(&checks).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&revision).Updates(map[string]interface{}{
+			"verification_status":  status,
+			"suggested_time_limit": suggested,
+		}).Error
+	})
+}
+
+// checkSolution judges one reference solution like a submission, except that
+// a main solution runs every test so its slowest time is known.
+func (e *Evaluator) checkSolution(ctx context.Context, revision *models.ProblemRevision, solution *models.ProblemProgram) (check models.SolutionCheck) {
+	ctx, span := tracing.Tracer().Start(ctx, "verify solution", trace.WithAttributes(
+		attribute.String("solution.name", solution.Name),
+		attribute.String("solution.expected", solution.Expected),
+	))
+	defer func() {
+		span.SetAttributes(attribute.String("solution.verdict", check.Verdict))
+		span.End()
+	}()
+
+	check = models.SolutionCheck{
+		RevisionID: revision.ID,
+		ProgramID:  solution.ID,
+		Name:       solution.Name,
+		Expected:   solution.Expected,
+		Verdict:    "accepted",
+	}
+
+	// Function-style solutions are judged through their language's harness
+	// like submissions are
+	wrapped := *solution
+	code, err := WrapSource(database.DB.WithContext(ctx), revision, solution.Language, solution.Source)
+	if err != nil {
+		check.Verdict = "compilation_error"
+		return check
+	}
+	wrapped.Source = code
+
+	program, err := e.compileProgram(&wrapped)
+	if err != nil {
+		check.Verdict = "compilation_error"
+		return check
+	}
+	defer program.cleanup()
+
+	for i, tc := range revision.TestCases {
+		result := e.runTestCase(solution.Language, revision, tc, program.codeFile)
+		if result.TimeUsed > check.MaxTime {
+			check.MaxTime = result.TimeUsed
+		}
+		if result.Status != "accepted" && check.FailedTest == 0 {
+			check.Verdict = result.Status
+			check.FailedTest = i + 1
+			if solution.Expected != models.ExpectMain {
+				break
+			}
+		}
+	}
+
+	check.Matches = models.SolutionExpectations[solution.Expected] == check.Verdict
+	return check
+}
+
+// suggestTimeLimit allows twice the main solution's slowest test, rounded up
+// to a multiple of 100 ms.
+func suggestTimeLimit(maxTime int) int {
+	limit := 2 * maxTime
+	if rem := limit % 100; rem != 0 {
+		limit += 100 - rem
+	}
+	if limit < 100 {
+		limit = 100
+	}
+	return limit
+}
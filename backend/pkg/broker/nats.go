@@ -1,19 +1,37 @@
 package broker
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 
 	"github.com/nats-io/nats.go"
 	"github.com/onlinejudge/backend/internal/models"
 	"github.com/onlinejudge/backend/internal/services"
+	"github.com/onlinejudge/backend/pkg/logging"
+	"github.com/onlinejudge/backend/pkg/metrics"
+	"github.com/onlinejudge/backend/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	SubmissionSubject = "submission.evaluate"
+	RevisionSubject   = "revision.prepare"
 )
 
+// revisionMessage asks the judge to complete a newly saved problem revision.
+type revisionMessage struct {
+	RevisionID uint `json:"revision_id"`
+}
+
 type NATSClient struct {
 	conn *nats.Conn
 }
@@ -32,28 +50,164 @@ func NewNATSClient() (*NATSClient, error) {
 	return &NATSClient{conn: nc}, nil
 }
 
-func (c *NATSClient) PublishSubmission(submission *models.Submission) error {
+// startPublish starts the producer span of a message to subject and returns
+// the message to send, which carries the request ID of ctx and the context
+// of the new span in its header.
+func startPublish(ctx context.Context, subject string, attrs ...attribute.KeyValue) (context.Context, trace.Span, *nats.Msg) {
+	ctx, span := tracing.Tracer().Start(ctx, subject+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(append([]attribute.KeyValue{
+			semconv.MessagingSystem("nats"),
+			semconv.MessagingDestinationName(subject),
+		}, attrs...)...),
+	)
+
+	msg := nats.NewMsg(subject)
+	if id := logging.RequestID(ctx); id != "" {
+		msg.Header.Set(logging.RequestIDHeader, id)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(http.Header(msg.Header)))
+	return ctx, span, msg
+}
+
+// startProcess starts the consumer span of a received message as a child
+// of the span that published it. The returned context carries the span,
+// the request ID from the message, or a new one for messages without it,
+// and logger tagged with both.
+func startProcess(msg *nats.Msg, logger *slog.Logger, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	requestID := msg.Header.Get(logging.RequestIDHeader)
+	if !logging.ValidRequestID(requestID) {
+		requestID = logging.NewRequestID()
+	}
+
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(http.Header(msg.Header)))
+	ctx, span := tracing.Tracer().Start(ctx, msg.Subject+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(append([]attribute.KeyValue{
+			semconv.MessagingSystem("nats"),
+			semconv.MessagingDestinationName(msg.Subject),
+		}, attrs...)...),
+	)
+
+	if sc := span.SpanContext(); sc.IsValid() {
+		logger = logger.With("trace_id", sc.TraceID().String())
+	}
+	return logging.WithRequestID(logging.WithLogger(ctx, logger), requestID), span
+}
+
+// PublishSubmission queues a submission for judging. The request ID in ctx
+// travels in the message header so the judge's logs can be tied to the
+// request that queued it, and so does the trace context, which makes the
+// judging part of the request's trace.
+func (c *NATSClient) PublishSubmission(ctx context.Context, submission *models.Submission) error {
+	ctx, span, msg := startPublish(ctx, SubmissionSubject, attribute.Int("submission.id", int(submission.ID)))
+	defer span.End()
+
 	data, err := json.Marshal(submission)
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
+	msg.Data = data
 
-	return c.conn.Publish(SubmissionSubject, data)
+	if err := c.conn.PublishMsg(msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		metrics.PublishFailures.WithLabelValues(SubmissionSubject).Inc()
+		logging.FromContext(ctx).ErrorContext(ctx, "failed to publish submission", "submission_id", submission.ID, "error", err)
+		return err
+	}
+	metrics.SubmissionsQueued.WithLabelValues(submission.Language).Inc()
+	return nil
 }
 
+// SubscribeToSubmissions judges submissions one at a time as they arrive.
+// Messages waiting for the evaluator are reported as the queue depth. Each
+// evaluation gets a logger tagged with the worker, the submission and the
+// request ID from the message, or a new one for messages without it, and
+// a span continuing the trace of the request that queued it.
 func (c *NATSClient) SubscribeToSubmissions(evaluator *services.Evaluator) error {
-	_, err := c.conn.Subscribe(SubmissionSubject, func(msg *nats.Msg) {
+	hostname, _ := os.Hostname()
+	workerID := fmt.Sprintf("%s-%d", hostname, os.Getpid())
+
+	sub, err := c.conn.Subscribe(SubmissionSubject, func(msg *nats.Msg) {
+		ctx, span := startProcess(msg, slog.Default().With("worker_id", workerID), attribute.String("worker.id", workerID))
+		defer span.End()
+
 		var submission models.Submission
 		if err := json.Unmarshal(msg.Data, &submission); err != nil {
-			log.Printf("Error unmarshaling submission: %v", err)
+			span.SetStatus(codes.Error, err.Error())
+			logging.FromContext(ctx).ErrorContext(ctx, "failed to decode submission", "error", err)
 			return
 		}
 
-		if err := evaluator.Evaluate(&submission); err != nil {
-			log.Printf("Error evaluating submission: %v", err)
+		span.SetAttributes(attribute.Int("submission.id", int(submission.ID)), attribute.String("submission.language", submission.Language))
+		ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("submission_id", submission.ID))
+		if err := evaluator.Evaluate(ctx, &submission); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			logging.FromContext(ctx).ErrorContext(ctx, "failed to evaluate submission", "error", err)
 		}
 	})
+	if err != nil {
+		return err
+	}
+
+	metrics.JudgeWorkers.Inc()
+	metrics.RegisterQueueDepth(func() float64 {
+		pending, _, _ := sub.Pending()
+		return float64(pending)
+	})
+	return nil
+}
+
+// PublishRevision queues a revision whose tests are still generating or
+// whose reference solutions are pending verification, so that authoring
+// programs run one at a time on the judge rather than in the request that
+// saved the problem.
+func (c *NATSClient) PublishRevision(ctx context.Context, revision *models.ProblemRevision) error {
+	ctx, span, msg := startPublish(ctx, RevisionSubject, attribute.Int("revision.id", int(revision.ID)))
+	defer span.End()
 
+	data, err := json.Marshal(revisionMessage{RevisionID: revision.ID})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	msg.Data = data
+
+	if err := c.conn.PublishMsg(msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		metrics.PublishFailures.WithLabelValues(RevisionSubject).Inc()
+		logging.FromContext(ctx).ErrorContext(ctx, "failed to publish revision", "revision_id", revision.ID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// SubscribeToRevisions prepares queued revisions one at a time, generating
+// and validating their tests and then verifying the reference solutions.
+func (c *NATSClient) SubscribeToRevisions(evaluator *services.Evaluator) error {
+	_, err := c.conn.Subscribe(RevisionSubject, func(msg *nats.Msg) {
+		ctx, span := startProcess(msg, slog.Default())
+		defer span.End()
+
+		var m revisionMessage
+		if err := json.Unmarshal(msg.Data, &m); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			logging.FromContext(ctx).ErrorContext(ctx, "failed to decode revision", "error", err)
+			return
+		}
+
+		span.SetAttributes(attribute.Int("revision.id", int(m.RevisionID)))
+		ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("revision_id", m.RevisionID))
+		if err := evaluator.PrepareRevision(ctx, m.RevisionID); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			logging.FromContext(ctx).ErrorContext(ctx, "failed to prepare revision", "error", err)
+		}
+	})
 	return err
 }
 
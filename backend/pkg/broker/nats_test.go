@@ -0,0 +1,93 @@
+package broker
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+	"github.com/onlinejudge/backend/pkg/logging"
+	"github.com/onlinejudge/backend/pkg/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestTraceContinuesThroughQueue checks that judging a submission joins the
+// trace of the request that queued it: the HTTP span is the parent of the
+// publish span, which is the parent of the process span.
+func TestTraceContinuesThroughQueue(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	const requestID = "0123456789abcdef0123456789abcdef"
+
+	var published *nats.Msg
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(otelgin.Middleware(tracing.ServiceName))
+	r.POST("/api/submissions", func(c *gin.Context) {
+		ctx := logging.WithRequestID(c.Request.Context(), requestID)
+		_, span, msg := startPublish(ctx, SubmissionSubject)
+		span.End()
+		published = msg
+		c.Status(http.StatusCreated)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/submissions", nil))
+	if w.Code != http.StatusCreated || published == nil {
+		t.Fatalf("request was not handled: status %d", w.Code)
+	}
+
+	ctx, span := startProcess(published, slog.Default())
+	span.End()
+
+	if got := logging.RequestID(ctx); got != requestID {
+		t.Errorf("request ID on the judge = %q, want %q", got, requestID)
+	}
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+	server, ok := spans["/api/submissions"]
+	if !ok {
+		t.Fatalf("no HTTP span among %d spans", len(spans))
+	}
+	publish, ok := spans[SubmissionSubject+" publish"]
+	if !ok {
+		t.Fatal("no publish span")
+	}
+	process, ok := spans[SubmissionSubject+" process"]
+	if !ok {
+		t.Fatal("no process span")
+	}
+
+	if server.SpanKind != trace.SpanKindServer || publish.SpanKind != trace.SpanKindProducer || process.SpanKind != trace.SpanKindConsumer {
+		t.Errorf("span kinds = %v, %v, %v", server.SpanKind, publish.SpanKind, process.SpanKind)
+	}
+	if publish.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Errorf("publish span's parent is %s, want the HTTP span %s", publish.Parent.SpanID(), server.SpanContext.SpanID())
+	}
+	if process.Parent.SpanID() != publish.SpanContext.SpanID() {
+		t.Errorf("process span's parent is %s, want the publish span %s", process.Parent.SpanID(), publish.SpanContext.SpanID())
+	}
+	if !process.Parent.IsRemote() {
+		t.Error("process span's parent should come from the message header")
+	}
+	for _, s := range []tracetest.SpanStub{publish, process} {
+		if s.SpanContext.TraceID() != server.SpanContext.TraceID() {
+			t.Errorf("%s is in trace %s, want %s", s.Name, s.SpanContext.TraceID(), server.SpanContext.TraceID())
+		}
+	}
+}
@@ -2,14 +2,14 @@ package database
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"time"
 
 	"github.com/onlinejudge/backend/internal/models"
+	"github.com/onlinejudge/backend/pkg/logging"
+	"github.com/onlinejudge/backend/pkg/tracing"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
 var DB *gorm.DB
@@ -40,40 +40,103 @@ func InitDB() (*gorm.DB, error) {
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		dbHost, dbPort, dbUser, dbPass, dbName)
 
-	// Configure GORM logger
-	newLogger := logger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags),
-		logger.Config{
-			SlowThreshold:             time.Second,
-			LogLevel:                  logger.Info,
-			IgnoreRecordNotFoundError: true,
-			Colorful:                  true,
-		},
-	)
-
-	// Connect to database
+	// Connect to database, logging failed and slow queries, and the rest
+	// at debug level
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: newLogger,
+		Logger: logging.NewGormLogger(time.Second),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %v", err)
 	}
 
+	// Trace queries made with a traced context
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		return nil, fmt.Errorf("failed to set up query tracing: %v", err)
+	}
+
+	// Accounts from before email verification existed count as verified,
+	// see the backfill below
+	backfillVerified := !db.Migrator().HasColumn(&models.User{}, "email_verified_at")
+
+	// Revisions from before they snapshotted points, policy, the test script
+	// and harnesses take them from their problem, see the backfill below
+	backfillRevisions := !db.Migrator().HasTable(&models.RevisionHarness{})
+
 	// Auto migrate models
 	if err := db.AutoMigrate(
 		&models.User{},
 		&models.Problem{},
 		&models.TestCase{},
+		&models.ProblemRevision{},
+		&models.ProblemProgram{},
+		&models.SolutionCheck{},
+		&models.ProblemStatement{},
+		&models.ProblemAttachment{},
 		&models.Tag{},
 		&models.Contest{},
 		&models.ContestProblem{},
 		&models.ContestUser{},
 		&models.Submission{},
 		&models.SubmissionResult{},
+		&models.ProblemStats{},
+		&models.ProblemVerdictCount{},
+		&models.ProblemLanguageStats{},
+		&models.ProblemRunBucket{},
+		&models.SampleRun{},
+		&models.SampleResult{},
+		&models.ProblemHarness{},
+		&models.RevisionHarness{},
+		&models.PlagiarismReport{},
+		&models.PlagiarismPair{},
+		&models.Session{},
+		&models.ResourceGrant{},
+		&models.APIToken{},
+		&models.AccountToken{},
+		&models.UserIdentity{},
+		&models.OIDCLoginState{},
+		&models.TwoFactor{},
+		&models.RecoveryCode{},
+		&models.LoginChallenge{},
+		&models.AuditLog{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %v", err)
 	}
 
+	if backfillVerified {
+		if err := db.Model(&models.User{}).
+			Where("email_verified_at IS NULL").
+			Update("email_verified_at", gorm.Expr("created_at")).Error; err != nil {
+			return nil, fmt.Errorf("failed to mark existing accounts verified: %v", err)
+		}
+	}
+
+	if backfillRevisions {
+		for _, stmt := range []string{
+			`UPDATE problem_revisions SET points = problems.points, test_script = problems.test_script,
+				signature = problems.signature, policy = problems.policy
+				FROM problems WHERE problems.id = problem_revisions.problem_id`,
+			`INSERT INTO revision_harnesses (revision_id, language, template, harness, created_at)
+				SELECT problem_revisions.id, problem_harnesses.language, problem_harnesses.template, problem_harnesses.harness, NOW()
+				FROM problem_revisions JOIN problem_harnesses ON problem_harnesses.problem_id = problem_revisions.problem_id`,
+		} {
+			if err := db.Exec(stmt).Error; err != nil {
+				return nil, fmt.Errorf("failed to backfill revisions: %v", err)
+			}
+		}
+	}
+
+	// Expression indexes backing full-text problem search
+	for _, stmt := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_problems_search ON problems
+			USING GIN (to_tsvector('simple', title || ' ' || description))`,
+		`CREATE INDEX IF NOT EXISTS idx_problem_statements_search ON problem_statements
+			USING GIN (to_tsvector('simple', title || ' ' || legend || ' ' || input_format || ' ' || output_format || ' ' || notes))`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			return nil, fmt.Errorf("failed to create search index: %v", err)
+		}
+	}
+
 	DB = db
 	return db, nil
 } 
\ No newline at end of file
@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestGormLoggerOmitsValues(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := WithLogger(context.Background(), slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: NewGormLogger(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type secret struct {
+		ID   uint
+		Hash string
+	}
+	const hash = "$2a$10$notarealhashbutsecretenough"
+	db = db.WithContext(ctx)
+	if err := db.AutoMigrate(&secret{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&secret{Hash: hash})
+	db.Where("hash = ?", hash).First(&secret{})
+	db.Table("missing").Where("hash = ?", hash).Find(&[]secret{}) // fails, logged as an error
+
+	logged := buf.String()
+	if !strings.Contains(logged, "query failed") || !strings.Contains(logged, "level=DEBUG") {
+		t.Fatalf("queries were not logged:\n%s", logged)
+	}
+	if strings.Contains(logged, hash) {
+		t.Errorf("a bound value was logged:\n%s", logged)
+	}
+}
@@ -0,0 +1,141 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/onlinejudge/backend/pkg/logging"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers account emails such as verification and password reset
+// links.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewMailer returns the mailer selected by MAIL_BACKEND: "smtp" for a real
+// mail server, "file" to write each message to MAIL_DIR (default "mail"),
+// anything else to write messages to the log.
+func NewMailer() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "noreply@localhost"
+	}
+
+	switch os.Getenv("MAIL_BACKEND") {
+	case "smtp":
+		return NewSMTPMailer(from)
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return NewFileMailer(dir, from)
+	default:
+		return &LogMailer{from: from}, nil
+	}
+}
+
+func format(from string, msg Message) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// checkHeaders rejects header injection through the recipient or subject.
+func checkHeaders(msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return errors.New("invalid mail header")
+	}
+	return nil
+}
+
+// SMTPMailer sends mail through the server at SMTP_HOST:SMTP_PORT,
+// authenticating with SMTP_USERNAME and SMTP_PASSWORD when set.
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTPMailer(from string) (*SMTPMailer, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil, errors.New("SMTP_HOST is required for the smtp mailer")
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	return &SMTPMailer{addr: host + ":" + port, auth: auth, from: from}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := checkHeaders(msg); err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, format(m.from, msg))
+}
+
+// FileMailer writes each message to its own .eml file, for development and
+// tests that need to read the links that were sent.
+type FileMailer struct {
+	dir  string
+	from string
+	seq  uint64
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %v", err)
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := checkHeaders(msg); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%d.eml", time.Now().UnixNano(), atomic.AddUint64(&m.seq, 1))
+	return os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg), 0644)
+}
+
+// LogMailer writes messages to the log instead of sending them.
+type LogMailer struct {
+	from string
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if err := checkHeaders(msg); err != nil {
+		return err
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "mail", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
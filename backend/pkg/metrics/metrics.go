@@ -0,0 +1,110 @@
+// Package metrics defines the Prometheus metrics of the API server and the
+// judge. The judge subscribes to submissions inside the API process, so one
+// endpoint serves both.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "onlinejudge"
+
+// judgeBuckets cover a compile or a whole evaluation, from a trivial
+// program to one hitting every time limit of a large test set.
+var judgeBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 40, 80, 160}
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to serve HTTP requests by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	SubmissionsQueued = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "submissions_queued_total",
+		Help:      "Submissions published for judging, rejudges included, by language.",
+	}, []string{"language"})
+
+	SubmissionsInProgress = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "submissions_in_progress",
+		Help:      "Submissions being judged by language.",
+	}, []string{"language"})
+
+	SubmissionsCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "submissions_completed_total",
+		Help:      "Judged submissions by language and verdict.",
+	}, []string{"language", "verdict"})
+
+	EvaluationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "evaluation_duration_seconds",
+		Help:      "Time to judge a submission, compilation included, by language.",
+		Buckets:   judgeBuckets,
+	}, []string{"language"})
+
+	CompileDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "compile_duration_seconds",
+		Help:      "Time to compile submissions, custom runs and authoring programs, by language and result (ok or error).",
+		Buckets:   judgeBuckets,
+	}, []string{"language", "result"})
+
+	JudgeErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "judge_errors_total",
+		Help:      "Submissions the judge failed to evaluate, as opposed to judging them with a failing verdict.",
+	})
+
+	JudgeWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "judge_workers",
+		Help:      "Judge workers subscribed to submissions.",
+	})
+
+	JudgeWorkersBusy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "judge_workers_busy",
+		Help:      "Judge workers evaluating a submission.",
+	})
+
+	JudgeBusySeconds = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "judge_busy_seconds_total",
+		Help:      "Time judge workers spent evaluating; its rate over judge_workers is their utilization.",
+	})
+
+	PublishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nats_publish_failures_total",
+		Help:      "Messages that could not be published to NATS by subject.",
+	}, []string{"subject"})
+)
+
+// RegisterQueueDepth reports pending as the number of submissions received
+// from NATS that are waiting for a judge worker.
+func RegisterQueueDepth(pending func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "judge_queue_depth",
+		Help:      "Submissions waiting for a judge worker.",
+	}, pending)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,309 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Rate allows Limit requests per Period, with bursts of up to Burst.
+type Rate struct {
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+// perSecond is the token refill rate of the bucket.
+func (r Rate) perSecond() float64 {
+	return float64(r.Limit) / r.Period.Seconds()
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s:%d", r.Limit, r.Period, r.Burst)
+}
+
+// ParseRate reads a rate such as "10/m" or "100/s:200", where the optional
+// number after the colon is the burst. The period is s, m or h.
+func ParseRate(s string) (Rate, error) {
+	var r Rate
+
+	spec, burst, hasBurst := strings.Cut(strings.TrimSpace(s), ":")
+	limit, period, ok := strings.Cut(spec, "/")
+	if !ok {
+		return r, fmt.Errorf("invalid rate %q", s)
+	}
+
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 {
+		return r, fmt.Errorf("invalid rate %q", s)
+	}
+	r.Limit = n
+	r.Burst = n
+
+	switch period {
+	case "s":
+		r.Period = time.Second
+	case "m":
+		r.Period = time.Minute
+	case "h":
+		r.Period = time.Hour
+	default:
+		return r, fmt.Errorf("invalid rate period in %q", s)
+	}
+
+	if hasBurst {
+		b, err := strconv.Atoi(burst)
+		if err != nil || b <= 0 {
+			return r, fmt.Errorf("invalid burst in %q", s)
+		}
+		r.Burst = b
+	}
+	return r, nil
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // until a token is available, when not allowed
+}
+
+// Limiter keeps token buckets for rate limiting and counters for lockouts.
+// Keys are shared between API processes when the backend is.
+type Limiter interface {
+	// Allow takes a token from the bucket under key.
+	Allow(ctx context.Context, key string, rate Rate) (Result, error)
+	// Incr adds one to the counter under key, which expires window after
+	// its first increment, and returns the count and time left.
+	Incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error)
+	// Count returns the counter under key and the time until it expires.
+	Count(ctx context.Context, key string) (int64, time.Duration, error)
+	// Reset deletes the counter under key.
+	Reset(ctx context.Context, key string) error
+}
+
+// NewLimiter returns the limiter selected by RATE_LIMIT_BACKEND: "redis"
+// for the server at REDIS_URL, shared by every API process, anything else
+// for one in memory.
+func NewLimiter() (Limiter, error) {
+	if os.Getenv("RATE_LIMIT_BACKEND") == "redis" {
+		return NewRedisLimiter(os.Getenv("REDIS_URL"))
+	}
+	return NewMemoryLimiter(), nil
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	expires time.Time
+}
+
+type counter struct {
+	count   int64
+	expires time.Time
+}
+
+// MemoryLimiter keeps buckets and counters in this process.
+type MemoryLimiter struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	counters map[string]*counter
+	sweep    time.Time
+	now      func() time.Time // replaced in tests
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets:  make(map[string]*bucket),
+		counters: make(map[string]*counter),
+		now:      time.Now,
+	}
+}
+
+// gc drops full buckets and expired counters once a minute so that one-off
+// clients do not accumulate.
+func (l *MemoryLimiter) gc(now time.Time) {
+	if now.Sub(l.sweep) < time.Minute {
+		return
+	}
+	l.sweep = now
+	for key, b := range l.buckets {
+		if now.After(b.expires) {
+			delete(l.buckets, key)
+		}
+	}
+	for key, c := range l.counters {
+		if now.After(c.expires) {
+			delete(l.counters, key)
+		}
+	}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, rate Rate) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.gc(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rate.Burst), updated: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(rate.Burst), b.tokens+now.Sub(b.updated).Seconds()*rate.perSecond())
+	b.updated = now
+	// Once refilled the bucket is the same as a new one
+	b.expires = now.Add(time.Duration(float64(rate.Burst) / rate.perSecond() * float64(time.Second)))
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / rate.perSecond()
+		return Result{RetryAfter: time.Duration(wait * float64(time.Second))}, nil
+	}
+	b.tokens--
+	return Result{Allowed: true, Remaining: int(b.tokens)}, nil
+}
+
+func (l *MemoryLimiter) Incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.gc(now)
+
+	c, ok := l.counters[key]
+	if !ok || now.After(c.expires) {
+		c = &counter{expires: now.Add(window)}
+		l.counters[key] = c
+	}
+	c.count++
+	return c.count, c.expires.Sub(now), nil
+}
+
+func (l *MemoryLimiter) Count(ctx context.Context, key string) (int64, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	c, ok := l.counters[key]
+	if !ok || now.After(c.expires) {
+		return 0, 0, nil
+	}
+	return c.count, c.expires.Sub(now), nil
+}
+
+func (l *MemoryLimiter) Reset(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.counters, key)
+	return nil
+}
+
+// tokenBucket updates a bucket atomically in Redis. Numbers are returned
+// as strings because Redis truncates Lua numbers to integers.
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = (1 - tokens) / rate
+end
+
+redis.call("HSET", KEYS[1], "tokens", tokens, "updated", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, tostring(tokens), tostring(wait)}
+`)
+
+// RedisLimiter keeps buckets and counters in Redis, so that limits hold
+// across API processes.
+type RedisLimiter struct {
+	client *redis.Client
+	now    func() time.Time // replaced in tests
+}
+
+// NewRedisLimiter connects to the Redis server at url, by default the
+// redis service from docker-compose on localhost.
+func NewRedisLimiter(url string) (*RedisLimiter, error) {
+	if url == "" {
+		url = "redis://localhost:6379/0"
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %v", err)
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %v", err)
+	}
+	return &RedisLimiter{client: client, now: time.Now}, nil
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, rate Rate) (Result, error) {
+	now := float64(l.now().UnixMicro()) / 1e6
+	values, err := tokenBucket.Run(ctx, l.client, []string{key}, rate.perSecond(), rate.Burst, now).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(values) != 3 {
+		return Result{}, fmt.Errorf("unexpected token bucket reply %v", values)
+	}
+
+	allowed, _ := values[0].(int64)
+	tokens, _ := strconv.ParseFloat(fmt.Sprint(values[1]), 64)
+	wait, _ := strconv.ParseFloat(fmt.Sprint(values[2]), 64)
+	return Result{
+		Allowed:    allowed == 1,
+		Remaining:  int(tokens),
+		RetryAfter: time.Duration(wait * float64(time.Second)),
+	}, nil
+}
+
+func (l *RedisLimiter) Incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	pipe := l.client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, window)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+	return incr.Val(), ttl.Val(), nil
+}
+
+func (l *RedisLimiter) Count(ctx context.Context, key string) (int64, time.Duration, error) {
+	pipe := l.client.Pipeline()
+	get := pipe.Get(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, 0, err
+	}
+	count, _ := get.Int64()
+	return count, ttl.Val(), nil
+}
+
+func (l *RedisLimiter) Reset(ctx context.Context, key string) error {
+	return l.client.Del(ctx, key).Err()
+}
@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeClock is a time that only moves when a test advances it.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+// testTokenBucket runs the same requests against the bucket of l, whose
+// clock is clock: one token a second with bursts of three.
+func testTokenBucket(t *testing.T, l Limiter, clock *fakeClock) {
+	ctx := context.Background()
+	rate := Rate{Limit: 60, Period: time.Minute, Burst: 3}
+
+	allow := func(key string) Result {
+		t.Helper()
+		result, err := l.Allow(ctx, key, rate)
+		if err != nil {
+			t.Fatalf("Allow() = %v", err)
+		}
+		return result
+	}
+
+	// A new bucket is full, so a burst goes through at once
+	for i := 2; i >= 0; i-- {
+		if r := allow("ip:1"); !r.Allowed || r.Remaining != i {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining", 3-i, r, i)
+		}
+	}
+	if r := allow("ip:1"); r.Allowed || r.RetryAfter != time.Second {
+		t.Fatalf("request over the burst = %+v, want denied for 1s", r)
+	}
+
+	// Buckets are per key
+	if r := allow("ip:2"); !r.Allowed {
+		t.Errorf("request of another key = %+v, want allowed", r)
+	}
+
+	// Half a token has been refilled, so the wait is half as long
+	clock.advance(500 * time.Millisecond)
+	if r := allow("ip:1"); r.Allowed || r.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("request after 500ms = %+v, want denied for 500ms", r)
+	}
+	clock.advance(500 * time.Millisecond)
+	if r := allow("ip:1"); !r.Allowed || r.Remaining != 0 {
+		t.Fatalf("request after 1s = %+v, want allowed with none remaining", r)
+	}
+
+	// The bucket refills up to the burst and no further
+	clock.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if r := allow("ip:1"); !r.Allowed {
+			t.Fatalf("request %d after an hour = %+v, want allowed", i+1, r)
+		}
+	}
+	if r := allow("ip:1"); r.Allowed || r.RetryAfter != time.Second {
+		t.Errorf("request over the burst after an hour = %+v, want denied for 1s", r)
+	}
+}
+
+func TestMemoryLimiterAllow(t *testing.T) {
+	clock := newFakeClock()
+	l := NewMemoryLimiter()
+	l.now = clock.now
+	testTokenBucket(t, l, clock)
+}
+
+func TestRedisLimiterAllow(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	clock := newFakeClock()
+	testTokenBucket(t, &RedisLimiter{client: client, now: clock.now}, clock)
+}
+
+func TestMemoryLimiterCounter(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	l := NewMemoryLimiter()
+	l.now = clock.now
+
+	for want := int64(1); want <= 3; want++ {
+		count, ttl, _ := l.Incr(ctx, "login:alice", time.Minute)
+		if count != want || ttl != time.Minute {
+			t.Fatalf("Incr() = %d, %v, want %d, 1m0s", count, ttl, want)
+		}
+	}
+
+	// Later increments do not extend the window
+	clock.advance(40 * time.Second)
+	if count, ttl, _ := l.Incr(ctx, "login:alice", time.Minute); count != 4 || ttl != 20*time.Second {
+		t.Errorf("Incr() after 40s = %d, %v, want 4, 20s", count, ttl)
+	}
+
+	clock.advance(21 * time.Second)
+	if count, ttl, _ := l.Count(ctx, "login:alice"); count != 0 || ttl != 0 {
+		t.Errorf("Count() after the window = %d, %v, want 0, 0s", count, ttl)
+	}
+	if count, _, _ := l.Incr(ctx, "login:alice", time.Minute); count != 1 {
+		t.Errorf("Incr() after the window = %d, want a new count of 1", count)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Rate
+		wantErr bool
+	}{
+		{in: "10/m", want: Rate{Limit: 10, Period: time.Minute, Burst: 10}},
+		{in: " 100/s:200 ", want: Rate{Limit: 100, Period: time.Second, Burst: 200}},
+		{in: "5/h:1", want: Rate{Limit: 5, Period: time.Hour, Burst: 1}},
+		{in: "10", wantErr: true},
+		{in: "0/m", wantErr: true},
+		{in: "10/d", wantErr: true},
+		{in: "10/m:0", wantErr: true},
+		{in: "10/m:x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRate(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseRate(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const gormSpanKey = "tracing:span"
+
+// GormPlugin adds a span for every query run with a context that is already
+// in a trace, such as db.WithContext(c.Request.Context()). Queries outside a
+// trace are left alone rather than each starting a trace of its own.
+type GormPlugin struct{}
+
+func (GormPlugin) Name() string {
+	return "tracing"
+}
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("tracing:before_create", startQuerySpan("create")),
+		cb.Create().After("gorm:create").Register("tracing:after_create", endQuerySpan),
+		cb.Query().Before("gorm:query").Register("tracing:before_query", startQuerySpan("query")),
+		cb.Query().After("gorm:query").Register("tracing:after_query", endQuerySpan),
+		cb.Update().Before("gorm:update").Register("tracing:before_update", startQuerySpan("update")),
+		cb.Update().After("gorm:update").Register("tracing:after_update", endQuerySpan),
+		cb.Delete().Before("gorm:delete").Register("tracing:before_delete", startQuerySpan("delete")),
+		cb.Delete().After("gorm:delete").Register("tracing:after_delete", endQuerySpan),
+		cb.Row().Before("gorm:row").Register("tracing:before_row", startQuerySpan("row")),
+		cb.Row().After("gorm:row").Register("tracing:after_row", endQuerySpan),
+		cb.Raw().Before("gorm:raw").Register("tracing:before_raw", startQuerySpan("raw")),
+		cb.Raw().After("gorm:raw").Register("tracing:after_raw", endQuerySpan),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startQuerySpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+			return
+		}
+
+		_, span := Tracer().Start(ctx, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL),
+		)
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+// endQuerySpan records the statement with its placeholders, never the
+// values, which may be passwords or source code.
+func endQuerySpan(db *gorm.DB) {
+	v, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	defer span.End()
+
+	span.SetAttributes(
+		semconv.DBStatementKey.String(db.Statement.SQL.String()),
+		semconv.DBSQLTableKey.String(db.Statement.Table),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+}